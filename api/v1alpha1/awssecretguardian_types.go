@@ -17,6 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,22 +28,942 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // AWSSecretGuardianSpec defines the desired state of AWSSecretGuardian
+// +kubebuilder:validation:XValidation:rule="has(self.provider) && self.provider == 'Vault' ? has(self.vault) : has(self.region) && size(self.region) > 0",message="region is required with the AWS provider and vault with the Vault provider"
+// +kubebuilder:validation:XValidation:rule="!has(self.passwordPolicy) || (has(self.passwordPolicy.minUpper) ? self.passwordPolicy.minUpper : 0) + (has(self.passwordPolicy.minLower) ? self.passwordPolicy.minLower : 0) + (has(self.passwordPolicy.minDigits) ? self.passwordPolicy.minDigits : 0) + (has(self.passwordPolicy.minSymbols) ? self.passwordPolicy.minSymbols : 0) <= self.length",message="the minimum counts of passwordPolicy add up to more than length"
+// +kubebuilder:validation:XValidation:rule="has(self.name) != (has(self.aws) && has(self.aws.secretName))",message="exactly one of aws.secretName or the deprecated name is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.aws) || !has(self.provider) || self.provider == 'AWS'",message="aws can only be set with the AWS provider"
+// +kubebuilder:validation:XValidation:rule="(has(self.target) && has(self.target.name)) || (has(self.aws) && has(self.aws.secretName) ? size(self.aws.secretName) <= 253 && self.aws.secretName.matches('^[a-z0-9]([-a-z0-9]*[a-z0-9])?([.][a-z0-9]([-a-z0-9]*[a-z0-9])?)*$') : !has(self.name) || (size(self.name) <= 253 && self.name.matches('^[a-z0-9]([-a-z0-9]*[a-z0-9])?([.][a-z0-9]([-a-z0-9]*[a-z0-9])?)*$')))",message="the secret name is not a valid Kubernetes Secret name, set target.name"
+// +kubebuilder:validation:XValidation:rule="!has(self.recoveryWindowInDays) || (has(self.deletionPolicy) && self.deletionPolicy == 'Delete')",message="recoveryWindowInDays can only be set with the Delete deletionPolicy"
 type AWSSecretGuardianSpec struct {
-	Region string   `json:"region"`
-	Name   string   `json:"name"`
-	Length int      `json:"length"`
-	TTL    int      `json:"ttl"`
-	Keys   []string `json:"keys"`
+	// Provider is the secret store the generated values are written to
+	// +kubebuilder:default=AWS
+	// +optional
+	Provider Provider `json:"provider,omitempty"`
+
+	// Region of the AWS Secret Manager, required with the AWS provider
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Name of the secret in the secret store, e.g. prod/payments/db.
+	// Also the name of the Kubernetes Secret when target.name is not set.
+	// Deprecated: use aws.secretName with the AWS provider. Still the path of the secret with the Vault provider
+	// +optional
+	Name   string `json:"name,omitempty"`
+	Length int    `json:"length"` // default length of the generated values
+	TTL    int    `json:"ttl"`
+
+	// AWS configures the secret in the AWS Secret Manager
+	// +optional
+	AWS *AWSSpec `json:"aws,omitempty"`
+
+	// Target is the Kubernetes Secret the values are written to
+	// +optional
+	Target *SecretTarget `json:"target,omitempty"`
+
+	// Keys of the secret and how their values are generated
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=name
+	Keys []KeySpec `json:"keys"`
+
+	// PasswordPolicy constrains the characters of the generated passwords.
+	// When omitted, passwords are drawn from uppercase, lowercase, digits and !@#$%^&*()_+-=[]{}|;:,.<>?~
+	// +optional
+	PasswordPolicy *PasswordPolicy `json:"passwordPolicy,omitempty"`
+
+	// Template renders extra keys of the secret from the values of its keys, e.g. a connection string
+	// +optional
+	Template *TemplateSpec `json:"template,omitempty"`
+
+	// CredentialsRef points to the Secret holding the AWS credentials used for this guardian.
+	// When omitted, the controller default credentials Secret is used.
+	// Only used with the Static auth type.
+	// +optional
+	CredentialsRef *CredentialsReference `json:"credentialsRef,omitempty"`
+
+	// Auth selects how the controller authenticates against AWS for this guardian.
+	// Defaults to static access keys. The other types use the AWS identity of the controller
+	// and are only allowed when the controller runs with --allow-controller-identity.
+	// +optional
+	Auth *AuthSpec `json:"auth,omitempty"`
+
+	// Vault configures the Vault provider
+	// +optional
+	Vault *VaultSpec `json:"vault,omitempty"`
+
+	// AdoptionPolicy of the secrets that exist before the guardian writes them, in the secret store and in Kubernetes.
+	// Fail refuses secrets not owned by the guardian, AdoptIfTagged adopts secrets tagged or annotated
+	// as owned by another guardian and AdoptAlways adopts every secret
+	// +kubebuilder:default=Fail
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// DeletionPolicy of the secret in the secret store when the guardian is deleted.
+	// Retain keeps the secret, Delete deletes it with a recovery window and
+	// ForceDeleteWithoutRecovery deletes it right away
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// RecoveryWindowInDays the secret can be restored after it is deleted, the store default when omitted
+	// +kubebuilder:validation:Minimum=7
+	// +kubebuilder:validation:Maximum=30
+	// +optional
+	RecoveryWindowInDays *int64 `json:"recoveryWindowInDays,omitempty"`
+
+	// DeletionTimeout after which the guardian is deleted even if the secret could not be deleted from the store,
+	// the secret is then left in the store, e.g. when its credentials were deleted before the guardian
+	// +kubebuilder:default="24h"
+	// +optional
+	DeletionTimeout *metav1.Duration `json:"deletionTimeout,omitempty"`
+}
+
+// SecretName is the name of the secret in the secret store, aws.secretName or the deprecated name
+func (s *AWSSecretGuardianSpec) SecretName() string {
+	if s.AWS != nil && s.AWS.SecretName != "" {
+		return s.AWS.SecretName
+	}
+	return s.Name
+}
+
+// AWSSpec defines the secret in the AWS Secret Manager
+type AWSSpec struct {
+	// SecretName is the name of the secret in the AWS Secret Manager, e.g. prod/payments/db.
+	// Also the name of the Kubernetes Secret when target.name is not set.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=512
+	SecretName string `json:"secretName"`
+}
+
+// AdoptionPolicy is how a guardian treats a secret it did not create
+// +kubebuilder:validation:Enum=Fail;AdoptIfTagged;AdoptAlways
+type AdoptionPolicy string
+
+// Adoption policies of the existing secrets
+const (
+	AdoptionPolicyFail          AdoptionPolicy = "Fail"
+	AdoptionPolicyAdoptIfTagged AdoptionPolicy = "AdoptIfTagged"
+	AdoptionPolicyAdoptAlways   AdoptionPolicy = "AdoptAlways"
+)
+
+// DeletionPolicy is what happens to the secret in the secret store when its guardian is deleted
+// +kubebuilder:validation:Enum=Retain;Delete;ForceDeleteWithoutRecovery
+type DeletionPolicy string
+
+// Deletion policies of the secret in the secret store
+const (
+	DeletionPolicyRetain                     DeletionPolicy = "Retain"
+	DeletionPolicyDelete                     DeletionPolicy = "Delete"
+	DeletionPolicyForceDeleteWithoutRecovery DeletionPolicy = "ForceDeleteWithoutRecovery"
+)
+
+// SecretTarget is the Kubernetes Secret written by a guardian
+// +kubebuilder:validation:XValidation:rule="has(self.dockerConfig) == (has(self.type) && self.type == 'kubernetes.io/dockerconfigjson')",message="dockerConfig is required with the kubernetes.io/dockerconfigjson type and only allowed with it"
+type SecretTarget struct {
+	// Name of the Secret, defaults to spec.aws.secretName or spec.name
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?([.][a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace of the Secret, defaults to the namespace of the guardian.
+	// Other namespaces are only allowed when the controller runs with --allow-cross-namespace-targets.
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Type of the Secret, defaults to kubernetes.io/tls when a certificate is written to tls.crt and Opaque otherwise.
+	// The keys required by the type must be written by the guardian: username or password for kubernetes.io/basic-auth,
+	// ssh-privatekey for kubernetes.io/ssh-auth, tls.crt and tls.key for kubernetes.io/tls
+	// +kubebuilder:validation:Enum=Opaque;kubernetes.io/tls;kubernetes.io/basic-auth;kubernetes.io/ssh-auth;kubernetes.io/dockerconfigjson
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// DockerConfig renders the .dockerconfigjson key of a kubernetes.io/dockerconfigjson Secret
+	// +optional
+	DockerConfig *DockerConfigSpec `json:"dockerConfig,omitempty"`
+
+	// Labels added to the Secret
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations added to the Secret, the annotations of the controller can not be overridden
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// DeletionPolicy of the Secret when the guardian is deleted.
+	// Delete sets an owner reference to the guardian so the Secret is garbage collected with it,
+	// Orphan keeps the Secret. Secrets in another namespace than the guardian are always orphaned
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy TargetDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// TargetDeletionPolicy is what happens to the Kubernetes Secret when its guardian is deleted
+// +kubebuilder:validation:Enum=Delete;Orphan
+type TargetDeletionPolicy string
+
+// Deletion policies of the Kubernetes Secret
+const (
+	TargetDeletionPolicyDelete TargetDeletionPolicy = "Delete"
+	TargetDeletionPolicyOrphan TargetDeletionPolicy = "Orphan"
+)
+
+// DockerConfigSpec is the registry of a kubernetes.io/dockerconfigjson Secret, its credentials are keys of the guardian
+type DockerConfigSpec struct {
+	// Server is the registry host, e.g. registry.example.com or https://index.docker.io/v1/
+	// +kubebuilder:validation:MinLength=1
+	Server string `json:"server"`
+
+	// UsernameKey is the key holding the registry username
+	// +kubebuilder:default=username
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+
+	// PasswordKey is the key holding the registry password
+	// +kubebuilder:default=password
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+
+	// Email of the registry account
+	// +optional
+	Email string `json:"email,omitempty"`
+}
+
+// TemplateSpec renders extra keys of the secret with Go text/template
+// the templates are executed with the values of spec.keys, e.g. {{ .password }} or {{ index . "tls.crt" }}
+type TemplateSpec struct {
+	// Data maps the rendered keys of the secret to their template
+	// +kubebuilder:validation:MinProperties=1
+	// +kubebuilder:validation:XValidation:rule="self.all(k, k.matches('^[-._a-zA-Z0-9]+$'))",message="the keys of data must consist of alphanumeric characters, '-', '_' or '.'"
+	Data map[string]string `json:"data"`
+}
+
+// GeneratorType selects how the value of a key is generated
+// +kubebuilder:validation:Enum=Password;UUID;Hex;Base64;Base64URL;Passphrase;PIN;KeyPair;SSHKeyPair;Certificate
+type GeneratorType string
+
+const (
+	// GeneratorPassword generates a random password of length characters, the default
+	GeneratorPassword GeneratorType = "Password"
+	// GeneratorUUID generates a random UUID version 4, the length is ignored
+	GeneratorUUID GeneratorType = "UUID"
+	// GeneratorHex generates length random bytes encoded as hex
+	GeneratorHex GeneratorType = "Hex"
+	// GeneratorBase64 generates length random bytes encoded as padded standard base64
+	GeneratorBase64 GeneratorType = "Base64"
+	// GeneratorBase64URL generates length random bytes encoded as unpadded URL safe base64
+	GeneratorBase64URL GeneratorType = "Base64URL"
+	// GeneratorPassphrase generates a passphrase of length words from the EFF short word list
+	GeneratorPassphrase GeneratorType = "Passphrase"
+	// GeneratorPIN generates a numeric PIN of length digits
+	GeneratorPIN GeneratorType = "PIN"
+	// GeneratorKeyPair generates an asymmetric key pair configured by keyPair, the length is ignored
+	GeneratorKeyPair GeneratorType = "KeyPair"
+	// GeneratorSSHKeyPair generates an SSH key pair configured by sshKeyPair, the length is ignored
+	GeneratorSSHKeyPair GeneratorType = "SSHKeyPair"
+	// GeneratorCertificate generates an X.509 certificate and its key configured by certificate, the length is ignored
+	GeneratorCertificate GeneratorType = "Certificate"
+)
+
+// KeyAlgorithm is the algorithm of a generated key pair
+// +kubebuilder:validation:Enum=RSA;ECDSA;Ed25519
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA     KeyAlgorithm = "RSA"
+	KeyAlgorithmECDSA   KeyAlgorithm = "ECDSA"
+	KeyAlgorithmEd25519 KeyAlgorithm = "Ed25519"
+)
+
+// KeyPairSpec configures the KeyPair generator
+// the PKCS#8 PEM private key is written to the key, the PKIX PEM public key and the JWK to their own keys
+// +kubebuilder:validation:XValidation:rule="!has(self.bits) || self.algorithm == 'RSA'",message="bits can only be set with the RSA algorithm"
+// +kubebuilder:validation:XValidation:rule="!has(self.curve) || self.algorithm == 'ECDSA'",message="curve can only be set with the ECDSA algorithm"
+type KeyPairSpec struct {
+	// Algorithm of the key pair
+	Algorithm KeyAlgorithm `json:"algorithm"`
+
+	// Bits is the size of an RSA key, defaults to 2048
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	// +optional
+	Bits int `json:"bits,omitempty"`
+
+	// Curve of an ECDSA key, defaults to P-256
+	// +kubebuilder:validation:Enum=P-256;P-384;P-521
+	// +optional
+	Curve string `json:"curve,omitempty"`
+
+	// PublicKeyName is the key of the PEM public key in the secret, defaults to <name>.pub
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	PublicKeyName string `json:"publicKeyName,omitempty"`
+
+	// JWKName is the key of the public key as a JWK in the secret, no JWK is written when empty
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	JWKName string `json:"jwkName,omitempty"`
+
+	// JWKS publishes the public keys of the key pair as a JWKS, with the previous keys kept for an overlap period
+	// +optional
+	JWKS *JWKSSpec `json:"jwks,omitempty"`
+}
+
+// JWKSSpec configures the JWKS of a signing key
+// the JWKS holds the current public key and the previous ones until their overlap ends
+type JWKSSpec struct {
+	// Name is the key of the JWKS in the secret and in the ConfigMap, defaults to jwks.json
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// ConfigMapName is the ConfigMap the JWKS is published to, in the namespace of the guardian
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
+	ConfigMapName string `json:"configMapName"`
+
+	// PreviousKeys is the maximum number of previous public keys kept in the JWKS
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=1
+	// +optional
+	PreviousKeys *int `json:"previousKeys,omitempty"`
+
+	// Overlap is how long a previous public key stays in the JWKS after the rotation
+	// +kubebuilder:default="24h"
+	// +optional
+	Overlap *metav1.Duration `json:"overlap,omitempty"`
+}
+
+// DefaultJWKSName is the key of a JWKS without a name
+const DefaultJWKSName = "jwks.json"
+
+// SSHKeyPairSpec configures the SSHKeyPair generator
+// the OpenSSH private key is written to the key and the authorized_keys line to its own key
+// +kubebuilder:validation:XValidation:rule="!has(self.bits) || self.type == 'RSA'",message="bits can only be set with the RSA type"
+// +kubebuilder:validation:XValidation:rule="!has(self.curve) || self.type == 'ECDSA'",message="curve can only be set with the ECDSA type"
+type SSHKeyPairSpec struct {
+	// Type of the key, defaults to Ed25519
+	// +kubebuilder:default=Ed25519
+	// +optional
+	Type KeyAlgorithm `json:"type,omitempty"`
+
+	// Bits is the size of an RSA key, defaults to 2048
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	// +optional
+	Bits int `json:"bits,omitempty"`
+
+	// Curve of an ECDSA key, defaults to P-256
+	// +kubebuilder:validation:Enum=P-256;P-384;P-521
+	// +optional
+	Curve string `json:"curve,omitempty"`
+
+	// Comment of the key, e.g. deploy@example.com, written in the private key and the authorized_keys line
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[^\r\n]*$`
+	// +optional
+	Comment string `json:"comment,omitempty"`
+
+	// PublicKeyName is the key of the authorized_keys line in the secret, defaults to <name>.pub
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	PublicKeyName string `json:"publicKeyName,omitempty"`
+}
+
+// KeyUsage is a key usage or an extended key usage of a certificate
+// +kubebuilder:validation:Enum=DigitalSignature;KeyEncipherment;KeyAgreement;CertSign;CRLSign;ServerAuth;ClientAuth;CodeSigning;EmailProtection
+type KeyUsage string
+
+const (
+	KeyUsageDigitalSignature KeyUsage = "DigitalSignature"
+	KeyUsageKeyEncipherment  KeyUsage = "KeyEncipherment"
+	KeyUsageKeyAgreement     KeyUsage = "KeyAgreement"
+	KeyUsageCertSign         KeyUsage = "CertSign"
+	KeyUsageCRLSign          KeyUsage = "CRLSign"
+	KeyUsageServerAuth       KeyUsage = "ServerAuth"
+	KeyUsageClientAuth       KeyUsage = "ClientAuth"
+	KeyUsageCodeSigning      KeyUsage = "CodeSigning"
+	KeyUsageEmailProtection  KeyUsage = "EmailProtection"
+)
+
+// Keys written by the Certificate generator besides the certificate
+const (
+	DefaultCertificatePrivateKeyName = "tls.key"
+	CertificateCAName                = "ca.crt"
+)
+
+// CertificateIssuerReference references a Secret holding a CA in its tls.crt and tls.key, in the namespace of the guardian
+type CertificateIssuerReference struct {
+	// Name of the Secret
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// CertificateSpec configures the Certificate generator
+// the PEM certificate is written to the key, its PKCS#8 PEM private key to privateKeyName and the CA certificate to ca.crt
+// +kubebuilder:validation:XValidation:rule="has(self.commonName) || has(self.dnsNames) || has(self.ipAddresses)",message="one of commonName, dnsNames or ipAddresses is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.bits) || self.algorithm == 'RSA'",message="bits can only be set with the RSA algorithm"
+// +kubebuilder:validation:XValidation:rule="!has(self.curve) || self.algorithm == 'ECDSA'",message="curve can only be set with the ECDSA algorithm"
+// +kubebuilder:validation:XValidation:rule="!has(self.renewBefore) || !has(self.duration) || duration(self.renewBefore) < duration(self.duration)",message="renewBefore must be shorter than duration"
+type CertificateSpec struct {
+	// CommonName of the subject of the certificate
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	CommonName string `json:"commonName,omitempty"`
+
+	// DNSNames are the DNS subject alternative names
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// IPAddresses are the IP subject alternative names
+	// +optional
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+
+	// Duration is the validity of the certificate, it never outlives its issuer
+	// +kubebuilder:default="2160h"
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// RenewBefore is how long before its expiry the certificate is renewed, defaults to a third of duration
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+
+	// Usages of the certificate, defaults to DigitalSignature, KeyEncipherment and ServerAuth, and CertSign for a CA
+	// +optional
+	Usages []KeyUsage `json:"usages,omitempty"`
+
+	// IsCA marks the certificate as a CA
+	// +optional
+	IsCA bool `json:"isCA,omitempty"`
+
+	// Algorithm of the private key
+	// +kubebuilder:default=RSA
+	// +optional
+	Algorithm KeyAlgorithm `json:"algorithm,omitempty"`
+
+	// Bits is the size of an RSA key, defaults to 2048
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	// +optional
+	Bits int `json:"bits,omitempty"`
+
+	// Curve of an ECDSA key, defaults to P-256
+	// +kubebuilder:validation:Enum=P-256;P-384;P-521
+	// +optional
+	Curve string `json:"curve,omitempty"`
+
+	// PrivateKeyName is the key of the private key in the secret, defaults to tls.key
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	PrivateKeyName string `json:"privateKeyName,omitempty"`
+
+	// IssuerRef is the CA signing the certificate, the certificate is self-signed if not set
+	// +optional
+	IssuerRef *CertificateIssuerReference `json:"issuerRef,omitempty"`
 }
 
+// HashAlgorithm is the algorithm of a hash of a key
+// +kubebuilder:validation:Enum=BCrypt;Argon2id;SHA256;Htpasswd
+type HashAlgorithm string
+
+const (
+	// HashBCrypt writes the bcrypt hash of the value
+	HashBCrypt HashAlgorithm = "BCrypt"
+	// HashArgon2id writes the Argon2id hash of the value in the PHC string format
+	HashArgon2id HashAlgorithm = "Argon2id"
+	// HashSHA256 writes the SHA-256 of the value as hex
+	HashSHA256 HashAlgorithm = "SHA256"
+	// HashHtpasswd writes an htpasswd line with the bcrypt hash of the value
+	HashHtpasswd HashAlgorithm = "Htpasswd"
+)
+
+// HashSpec writes a hash of the value of a key to another key of the secret
+// +kubebuilder:validation:XValidation:rule="!has(self.cost) || self.algorithm == 'BCrypt' || self.algorithm == 'Htpasswd'",message="cost can only be set with the BCrypt and Htpasswd algorithms"
+// +kubebuilder:validation:XValidation:rule="self.algorithm == 'Htpasswd' ? has(self.username) != has(self.usernameKey) : !has(self.username) && !has(self.usernameKey)",message="the Htpasswd algorithm requires one of username or usernameKey, the other algorithms allow neither"
+type HashSpec struct {
+	// Algorithm of the hash
+	Algorithm HashAlgorithm `json:"algorithm"`
+
+	// Name is the key of the hash in the secret, defaults to <name>.<algorithm in lowercase>, e.g. password.bcrypt
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Cost of bcrypt, defaults to 10
+	// +kubebuilder:validation:Minimum=4
+	// +kubebuilder:validation:Maximum=16
+	// +optional
+	Cost *int `json:"cost,omitempty"`
+
+	// Username of the htpasswd line
+	// +kubebuilder:validation:Pattern=`^[^:\r\n]+$`
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// UsernameKey is the key of the secret holding the username of the htpasswd line
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+}
+
+// KeySpec defines a key of the secret and how its value is generated
+// +kubebuilder:validation:XValidation:rule="!has(self.value) || (!has(self.generator) && !has(self.length) && !has(self.passwordPolicy) && !has(self.separator))",message="a key with a fixed value can not set generator, length, passwordPolicy or separator"
+// +kubebuilder:validation:XValidation:rule="!has(self.passwordPolicy) || !has(self.generator) || self.generator == 'Password'",message="passwordPolicy can only be set with the Password generator"
+// +kubebuilder:validation:XValidation:rule="!has(self.separator) || (has(self.generator) && self.generator == 'Passphrase')",message="separator can only be set with the Passphrase generator"
+// +kubebuilder:validation:XValidation:rule="has(self.generator) && self.generator == 'KeyPair' ? has(self.keyPair) : !has(self.keyPair)",message="keyPair is required with the KeyPair generator and only allowed with it"
+// +kubebuilder:validation:XValidation:rule="has(self.generator) && self.generator == 'SSHKeyPair' ? has(self.sshKeyPair) : !has(self.sshKeyPair)",message="sshKeyPair is required with the SSHKeyPair generator and only allowed with it"
+// +kubebuilder:validation:XValidation:rule="has(self.generator) && self.generator == 'Certificate' ? has(self.certificate) : !has(self.certificate)",message="certificate is required with the Certificate generator and only allowed with it"
+// +kubebuilder:validation:XValidation:rule="!has(self.hashes) || !has(self.generator) || !(self.generator in ['KeyPair', 'SSHKeyPair', 'Certificate'])",message="hashes can not be set with the KeyPair, SSHKeyPair and Certificate generators"
+// +kubebuilder:validation:XValidation:rule="!has(self.length) || !has(self.passwordPolicy) || (has(self.passwordPolicy.minUpper) ? self.passwordPolicy.minUpper : 0) + (has(self.passwordPolicy.minLower) ? self.passwordPolicy.minLower : 0) + (has(self.passwordPolicy.minDigits) ? self.passwordPolicy.minDigits : 0) + (has(self.passwordPolicy.minSymbols) ? self.passwordPolicy.minSymbols : 0) <= self.length",message="the minimum counts of passwordPolicy add up to more than length"
+type KeySpec struct {
+	// Name of the key in the secret
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	Name string `json:"name"`
+
+	// Generator of the value, defaults to Password
+	// +optional
+	Generator GeneratorType `json:"generator,omitempty"`
+
+	// Length of the generated value, defaults to spec.length.
+	// Characters for Password and PIN, random bytes for Hex, Base64 and Base64URL, words for Passphrase.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Length *int `json:"length,omitempty"`
+
+	// Separator of the words of a Passphrase, defaults to "-"
+	// +kubebuilder:validation:MaxLength=8
+	// +optional
+	Separator *string `json:"separator,omitempty"`
+
+	// PasswordPolicy of the key, defaults to spec.passwordPolicy
+	// +optional
+	PasswordPolicy *PasswordPolicy `json:"passwordPolicy,omitempty"`
+
+	// KeyPair configures the KeyPair generator
+	// +optional
+	KeyPair *KeyPairSpec `json:"keyPair,omitempty"`
+
+	// SSHKeyPair configures the SSHKeyPair generator
+	// +optional
+	SSHKeyPair *SSHKeyPairSpec `json:"sshKeyPair,omitempty"`
+
+	// Certificate configures the Certificate generator
+	// +optional
+	Certificate *CertificateSpec `json:"certificate,omitempty"`
+
+	// Hashes of the value written to other keys of the secret, computed on every rotation from the same value
+	// +kubebuilder:validation:MaxItems=8
+	// +optional
+	Hashes []HashSpec `json:"hashes,omitempty"`
+
+	// Value is a fixed value, the key is never generated
+	// +optional
+	Value *string `json:"value,omitempty"`
+
+	// Rotate is false for keys generated once and kept on every rotation, e.g. a username
+	// +kubebuilder:default=true
+	// +optional
+	Rotate *bool `json:"rotate,omitempty"`
+}
+
+// UnmarshalJSON also accepts the name of the key as a plain string,
+// the format of spec.keys before keys became objects
+func (k *KeySpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*k = KeySpec{Name: name}
+		return nil
+	}
+	type keySpec KeySpec // same fields without the UnmarshalJSON method
+	return json.Unmarshal(data, (*keySpec)(k))
+}
+
+// Rotates is true if the value of the key is generated again on every rotation
+func (k *KeySpec) Rotates() bool {
+	return k.Value == nil && (k.Rotate == nil || *k.Rotate)
+}
+
+// SecretKeys are the keys of the secret written for the key, its name and the extra keys of its generator
+func (k *KeySpec) SecretKeys() []string {
+	keys := []string{k.Name}
+	if k.Generator == GeneratorKeyPair && k.KeyPair != nil {
+		publicKeyName := k.KeyPair.PublicKeyName
+		if publicKeyName == "" {
+			publicKeyName = k.Name + ".pub"
+		}
+		keys = append(keys, publicKeyName)
+		if k.KeyPair.JWKName != "" {
+			keys = append(keys, k.KeyPair.JWKName)
+		}
+		if name := k.JWKSName(); name != "" {
+			keys = append(keys, name)
+		}
+	}
+	if k.Generator == GeneratorSSHKeyPair && k.SSHKeyPair != nil {
+		publicKeyName := k.SSHKeyPair.PublicKeyName
+		if publicKeyName == "" {
+			publicKeyName = k.Name + ".pub"
+		}
+		keys = append(keys, publicKeyName)
+	}
+	if k.Generator == GeneratorCertificate && k.Certificate != nil {
+		privateKeyName := k.Certificate.PrivateKeyName
+		if privateKeyName == "" {
+			privateKeyName = DefaultCertificatePrivateKeyName
+		}
+		keys = append(keys, privateKeyName)
+		if k.Certificate.IssuerRef != nil {
+			keys = append(keys, CertificateCAName)
+		}
+	}
+	for i := range k.Hashes {
+		keys = append(keys, k.HashName(&k.Hashes[i]))
+	}
+	return keys
+}
+
+// HashName is the key of a hash of the key in the secret
+func (k *KeySpec) HashName(hash *HashSpec) string {
+	if hash.Name != "" {
+		return hash.Name
+	}
+	return k.Name + "." + strings.ToLower(string(hash.Algorithm))
+}
+
+// JWKSName is the key of the JWKS of a key pair in the secret, empty without a JWKS
+func (k *KeySpec) JWKSName() string {
+	if k.Generator != GeneratorKeyPair || k.KeyPair == nil || k.KeyPair.JWKS == nil {
+		return ""
+	}
+	if k.KeyPair.JWKS.Name == "" {
+		return DefaultJWKSName
+	}
+	return k.KeyPair.JWKS.Name
+}
+
+// CharacterClass is a class of characters of a password
+// +kubebuilder:validation:Enum=Upper;Lower;Digit;Symbol
+type CharacterClass string
+
+const (
+	CharacterClassUpper  CharacterClass = "Upper"
+	CharacterClassLower  CharacterClass = "Lower"
+	CharacterClassDigit  CharacterClass = "Digit"
+	CharacterClassSymbol CharacterClass = "Symbol"
+)
+
+// PasswordPolicy constrains the characters of a generated password
+// +kubebuilder:validation:XValidation:rule="!has(self.minUpper) || self.minUpper == 0 || !has(self.include) || 'Upper' in self.include",message="minUpper requires Upper in include"
+// +kubebuilder:validation:XValidation:rule="!has(self.minLower) || self.minLower == 0 || !has(self.include) || 'Lower' in self.include",message="minLower requires Lower in include"
+// +kubebuilder:validation:XValidation:rule="!has(self.minDigits) || self.minDigits == 0 || !has(self.include) || 'Digit' in self.include",message="minDigits requires Digit in include"
+// +kubebuilder:validation:XValidation:rule="!has(self.minSymbols) || self.minSymbols == 0 || !has(self.include) || 'Symbol' in self.include",message="minSymbols requires Symbol in include"
+// +kubebuilder:validation:XValidation:rule="!has(self.startWithLetter) || !self.startWithLetter || !has(self.include) || 'Upper' in self.include || 'Lower' in self.include",message="startWithLetter requires Upper or Lower in include"
+type PasswordPolicy struct {
+	// Include lists the character classes passwords are drawn from, all of them by default
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=4
+	// +listType=set
+	// +optional
+	Include []CharacterClass `json:"include,omitempty"`
+
+	// Symbols replaces the characters of the Symbol class, e.g. "!#%+-_" for passwords used in URLs
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	Symbols string `json:"symbols,omitempty"`
+
+	// Exclude lists characters that are never used
+	// +kubebuilder:validation:MaxLength=128
+	// +optional
+	Exclude string `json:"exclude,omitempty"`
+
+	// MinUpper is the minimum number of uppercase letters
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinUpper int `json:"minUpper,omitempty"`
+
+	// MinLower is the minimum number of lowercase letters
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinLower int `json:"minLower,omitempty"`
+
+	// MinDigits is the minimum number of digits
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinDigits int `json:"minDigits,omitempty"`
+
+	// MinSymbols is the minimum number of symbols
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinSymbols int `json:"minSymbols,omitempty"`
+
+	// NoAmbiguous excludes the characters that look alike, 0Oo1lI|
+	// +optional
+	NoAmbiguous bool `json:"noAmbiguous,omitempty"`
+
+	// NoRepeatedCharacters forbids a character directly following itself,
+	// each class with a minimum count must keep two characters or more after the exclusions
+	// +optional
+	NoRepeatedCharacters bool `json:"noRepeatedCharacters,omitempty"`
+
+	// StartWithLetter makes the first character an uppercase or lowercase letter
+	// +optional
+	StartWithLetter bool `json:"startWithLetter,omitempty"`
+}
+
+// Provider is the secret store of a guardian
+// +kubebuilder:validation:Enum=AWS;Vault
+type Provider string
+
+const (
+	// ProviderAWS stores the secret in the AWS Secret Manager
+	ProviderAWS Provider = "AWS"
+	// ProviderVault stores the secret in a HashiCorp Vault KV v2 secrets engine
+	ProviderVault Provider = "Vault"
+)
+
+// VaultSpec defines where and how the secret is written to Vault
+// a rotation writes the new value as the latest KV version before the Kubernetes Secret is updated,
+// the promoted version is recorded in the guardian-current-version custom metadata of the secret
+type VaultSpec struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200
+	// must be one of the --vault-addresses of the controller
+	Address string `json:"address"`
+
+	// Namespace is the Vault Enterprise namespace, if any
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Mount is the path of the KV v2 secrets engine
+	// +kubebuilder:default=secret
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// PathPrefix is prepended to spec.name to build the path of the secret in the mount
+	// +optional
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// Auth selects how the controller logs in to Vault
+	Auth VaultAuthSpec `json:"auth"`
+}
+
+// VaultAuthMethod is the way the controller logs in to Vault
+// +kubebuilder:validation:Enum=Token;Kubernetes;AppRole
+type VaultAuthMethod string
+
+const (
+	// VaultAuthToken uses a token read from a Secret
+	VaultAuthToken VaultAuthMethod = "Token"
+	// VaultAuthKubernetes logs in with the service account token of the controller,
+	// only allowed when the controller runs with --allow-vault-kubernetes-auth
+	VaultAuthKubernetes VaultAuthMethod = "Kubernetes"
+	// VaultAuthAppRole logs in with a role id and a secret id read from a Secret
+	VaultAuthAppRole VaultAuthMethod = "AppRole"
+)
+
+// VaultAuthSpec defines how the controller logs in to Vault
+// +kubebuilder:validation:XValidation:rule="self.method != 'Token' || has(self.tokenSecretRef)",message="tokenSecretRef is required with the Token method"
+// +kubebuilder:validation:XValidation:rule="self.method != 'Kubernetes' || has(self.kubernetes)",message="kubernetes is required with the Kubernetes method"
+// +kubebuilder:validation:XValidation:rule="self.method != 'AppRole' || has(self.appRole)",message="appRole is required with the AppRole method"
+type VaultAuthSpec struct {
+	// Method used to log in
+	Method VaultAuthMethod `json:"method"`
+
+	// TokenSecretRef points to the Secret holding the Vault token, used with the Token method
+	// +optional
+	TokenSecretRef *SecretKeyReference `json:"tokenSecretRef,omitempty"`
+
+	// Kubernetes configures the Kubernetes method
+	// +optional
+	Kubernetes *VaultKubernetesAuth `json:"kubernetes,omitempty"`
+
+	// AppRole configures the AppRole method
+	// +optional
+	AppRole *VaultAppRoleAuth `json:"appRole,omitempty"`
+}
+
+// VaultKubernetesAuth configures the Vault Kubernetes auth method
+type VaultKubernetesAuth struct {
+	// Role is the Vault role to log in with
+	Role string `json:"role"`
+
+	// MountPath of the auth method
+	// +kubebuilder:default=kubernetes
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// VaultAppRoleAuth configures the Vault AppRole auth method
+type VaultAppRoleAuth struct {
+	// RoleID of the AppRole
+	RoleID string `json:"roleId"`
+
+	// SecretIDRef points to the Secret holding the secret id of the AppRole
+	SecretIDRef SecretKeyReference `json:"secretIdRef"`
+
+	// MountPath of the auth method
+	// +kubebuilder:default=approle
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// SecretKeyReference points to a key of a Secret in the namespace of the guardian
+type SecretKeyReference struct {
+	// Name of the Secret
+	Name string `json:"name"`
+
+	// Key in the Secret
+	Key string `json:"key"`
+}
+
+// AuthType is the way the controller authenticates against AWS
+// +kubebuilder:validation:Enum=Static;Default;WebIdentity;PodIdentity
+type AuthType string
+
+const (
+	// AuthTypeStatic uses the access keys of the credentials Secret
+	AuthTypeStatic AuthType = "Static"
+	// AuthTypeDefault uses the AWS SDK default credential chain of the controller
+	AuthTypeDefault AuthType = "Default"
+	// AuthTypeWebIdentity uses an IRSA web identity token file
+	AuthTypeWebIdentity AuthType = "WebIdentity"
+	// AuthTypePodIdentity uses the EKS Pod Identity agent
+	AuthTypePodIdentity AuthType = "PodIdentity"
+)
+
+// AuthSpec defines how the controller authenticates against AWS
+type AuthSpec struct {
+	// Type of authentication
+	// +kubebuilder:default=Static
+	// +optional
+	Type AuthType `json:"type,omitempty"`
+
+	// WebIdentity configures the WebIdentity auth type.
+	// The token is read from the AWS_WEB_IDENTITY_TOKEN_FILE of the controller.
+	// +optional
+	WebIdentity *WebIdentityAuth `json:"webIdentity,omitempty"`
+
+	// AssumeRole is assumed with the credentials of the auth type, so the guardian can write into another AWS account
+	// +optional
+	AssumeRole *AssumeRoleSpec `json:"assumeRole,omitempty"`
+}
+
+// AssumeRoleSpec configures the role assumed on top of the base credentials
+type AssumeRoleSpec struct {
+	// RoleARN is the ARN of the role to assume
+	RoleARN string `json:"roleArn"`
+
+	// ExternalID is passed to STS when the trust policy of the role requires it
+	// +optional
+	ExternalID string `json:"externalId,omitempty"`
+
+	// SessionName is the name of the role session, defaults to <namespace>-<name> of the guardian
+	// +optional
+	SessionName string `json:"sessionName,omitempty"`
+
+	// DurationSeconds is the lifetime of the temporary credentials
+	// +kubebuilder:validation:Minimum=900
+	// +kubebuilder:validation:Maximum=43200
+	// +optional
+	DurationSeconds *int64 `json:"durationSeconds,omitempty"`
+
+	// Tags are the session tags passed to STS
+	// +optional
+	Tags []SessionTag `json:"tags,omitempty"`
+}
+
+// SessionTag is a tag attached to an assumed role session
+type SessionTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// WebIdentityAuth configures authentication with a web identity token file
+type WebIdentityAuth struct {
+	// RoleARN is the ARN of the role to assume with the web identity token, defaults to the AWS_ROLE_ARN of the controller
+	// +optional
+	RoleARN string `json:"roleArn,omitempty"`
+
+	// SessionName is the name of the role session
+	// +optional
+	SessionName string `json:"sessionName,omitempty"`
+}
+
+// CredentialsReference points to a Secret holding static AWS credentials
+type CredentialsReference struct {
+	// Name of the Secret
+	Name string `json:"name"`
+
+	// Namespace of the Secret, defaults to the namespace of the guardian.
+	// A guardian can only reference a Secret in its own namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// AccessKeyIDKey is the key of the access key id in the Secret
+	// +kubebuilder:default=access-key
+	// +optional
+	AccessKeyIDKey string `json:"accessKeyIDKey,omitempty"`
+
+	// SecretAccessKeyKey is the key of the secret access key in the Secret
+	// +kubebuilder:default=secret-access-key
+	// +optional
+	SecretAccessKeyKey string `json:"secretAccessKeyKey,omitempty"`
+
+	// SessionTokenKey is the key of the session token in the Secret, if any
+	// +optional
+	SessionTokenKey string `json:"sessionTokenKey,omitempty"`
+}
+
+// Condition types reported in AWSSecretGuardianStatus.Conditions
+const (
+	// ConditionReady is true when the AWS secret and the Kubernetes Secret are in sync
+	ConditionReady = "Ready"
+	// ConditionRotated is true when the last rotation attempt succeeded
+	ConditionRotated = "Rotated"
+	// ConditionAWSReachable is true when the controller could authenticate against AWS
+	ConditionAWSReachable = "AWSReachable"
+	// ConditionDegraded is true when the last reconcile failed
+	ConditionDegraded = "Degraded"
+)
+
 // AWSSecretGuardianStatus defines the observed state of AWSSecretGuardian
 type AWSSecretGuardianStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastRotationTime is the time the secret was last rotated
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// NextRotationTime is the time the secret is due for its next rotation
+	// +optional
+	NextRotationTime *metav1.Time `json:"nextRotationTime,omitempty"`
+
+	// AWSSecretARN is the ARN of the secret in AWS Secrets Manager
+	// +optional
+	AWSSecretARN string `json:"awsSecretARN,omitempty"`
+
+	// AWSVersionID is the version id of the current secret value in AWS Secrets Manager
+	// +optional
+	AWSVersionID string `json:"awsVersionId,omitempty"`
+
+	// Conditions represent the latest available observations of the guardian's state
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Last Rotation",type="date",JSONPath=".status.lastRotationTime"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // AWSSecretGuardian is the Schema for the awssecretguardians API
 type AWSSecretGuardian struct {
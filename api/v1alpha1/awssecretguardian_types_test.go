@@ -0,0 +1,35 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestKeySpecUnmarshalLegacyString(t *testing.T) {
+	var spec AWSSecretGuardianSpec
+	if err := json.Unmarshal([]byte(`{"name":"db","length":16,"ttl":60,"keys":["username",{"name":"password","length":32}]}`), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Keys) != 2 || spec.Keys[0].Name != "username" || spec.Keys[1].Name != "password" || spec.Keys[1].Length == nil || *spec.Keys[1].Length != 32 {
+		t.Errorf("keys = %+v", spec.Keys)
+	}
+	if !spec.Keys[0].Rotates() {
+		t.Error("a key without value and rotate does not rotate")
+	}
+}
@@ -19,12 +19,14 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -52,11 +54,33 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var defaultCredentialsSecret string
+	var allowCrossNamespaceTargets bool
+	var allowControllerIdentity bool
+	var vaultAddresses string
+	var allowVaultKubernetesAuth bool
+	var vaultKubernetesTokenFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&defaultCredentialsSecret, "default-credentials-secret", "awssecretguardian/aws-creds",
+		"The <namespace>/<name> of the AWS credentials Secret used by guardians without a credentialsRef. "+
+			"Set it to an empty string to require a credentialsRef on every guardian.")
+	flag.BoolVar(&allowCrossNamespaceTargets, "allow-cross-namespace-targets", false,
+		"Allow guardians to write their Secret to another namespace with spec.target.namespace. "+
+			"Anyone allowed to create a guardian can then overwrite Secrets in every namespace.")
+	flag.BoolVar(&allowControllerIdentity, "allow-controller-identity", false,
+		"Allow guardians to use the AWS identity of the controller pod with the Default, WebIdentity and PodIdentity auth types. "+
+			"Anyone allowed to create a guardian can then act with that identity.")
+	flag.StringVar(&vaultAddresses, "vault-addresses", "",
+		"Comma separated addresses of the Vault servers guardians may use. The Vault provider is refused if empty.")
+	flag.BoolVar(&allowVaultKubernetesAuth, "allow-vault-kubernetes-auth", false,
+		"Allow guardians to log in to the Vault servers of --vault-addresses with the Kubernetes method, "+
+			"using the service account token of the controller. Anyone allowed to create a guardian can then act with the Vault roles of the controller.")
+	flag.StringVar(&vaultKubernetesTokenFile, "vault-kubernetes-token-file", controller.DefaultVaultKubernetesTokenFile,
+		"The service account token used to log in to Vault with the Kubernetes method.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +89,27 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	var defaultCredentials types.NamespacedName
+	if defaultCredentialsSecret != "" {
+		namespace, name, ok := strings.Cut(defaultCredentialsSecret, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --default-credentials-secret, expected <namespace>/<name>", "value", defaultCredentialsSecret)
+			os.Exit(1)
+		}
+		defaultCredentials = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	var allowedVaultAddresses []string
+	for _, address := range strings.Split(vaultAddresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			allowedVaultAddresses = append(allowedVaultAddresses, address)
+		}
+	}
+	if allowVaultKubernetesAuth && len(allowedVaultAddresses) == 0 {
+		setupLog.Error(nil, "--allow-vault-kubernetes-auth requires --vault-addresses, the service account token of the controller must only be sent to trusted Vault servers")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -90,8 +135,14 @@ func main() {
 	}
 
 	if err = (&controller.AWSSecretGuardianReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                     mgr.GetClient(),
+		Scheme:                     mgr.GetScheme(),
+		DefaultCredentialsSecret:   defaultCredentials,
+		AllowCrossNamespaceTargets: allowCrossNamespaceTargets,
+		AllowControllerIdentity:    allowControllerIdentity,
+		VaultAddresses:             allowedVaultAddresses,
+		AllowVaultKubernetesAuth:   allowVaultKubernetesAuth,
+		VaultKubernetesTokenFile:   vaultKubernetesTokenFile,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AWSSecretGuardian")
 		os.Exit(1)
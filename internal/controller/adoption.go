@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/awsstore"
+)
+
+// Ownership markers of the secrets written by a guardian, both hold the <namespace>/<name> of the guardian
+const (
+	// OwnerTag is the tag of the secret in the store
+	OwnerTag = "secretguardian.omerap12.com/owner"
+	// OwnerAnnotation is the annotation of the k8s secret
+	OwnerAnnotation = "secretguardian.omerap12.com/owner"
+)
+
+// ErrAdoptionRefused is returned when a secret exists but the adoption policy of the guardian does not allow to take it over
+var ErrAdoptionRefused = errors.New("adoption refused")
+
+// function to get the owner written to the tag and the annotation of the secrets of the guardian
+// return the <namespace>/<name> of the guardian
+func GuardianOwner(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) string {
+	return awsSecretGuardian.Namespace + "/" + awsSecretGuardian.Name
+}
+
+// function to check if the secret of the store is owned by the guardian
+// a secret written before the owner tag existed is recognized by the id recorded in the status,
+// or by the description the first versions of the controller created every secret with, they recorded no status,
+// a secret tagged for another guardian, e.g. adopted by it, is not owned even if the guardian wrote it before
+// return true if the secret is tagged for the guardian or is untagged and was written by it
+func OwnsStoreSecret(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, info *secretstore.SecretInfo) bool {
+	owner, tagged := info.Tags[OwnerTag]
+	if tagged {
+		return owner == GuardianOwner(awsSecretGuardian)
+	}
+	if info.Description == awsstore.Description {
+		return true
+	}
+	return info.ID != "" && awsSecretGuardian.Status.AWSSecretARN == info.ID
+}
+
+// function to check if the guardian may write the existing secret of the store
+// secrets not owned by the guardian are adopted according to the adoption policy: secrets tagged for another guardian with AdoptIfTagged,
+// every secret with AdoptAlways
+// return true if the secret must be tagged for the guardian, an error wrapping ErrAdoptionRefused otherwise
+func CheckStoreOwnership(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, info *secretstore.SecretInfo) (bool, error) {
+	if info.Tags[OwnerTag] == GuardianOwner(awsSecretGuardian) {
+		return false, nil
+	}
+	if OwnsStoreSecret(awsSecretGuardian, info) {
+		return true, nil
+	}
+	owner, tagged := info.Tags[OwnerTag]
+	policy := awsSecretGuardian.Spec.AdoptionPolicy
+	if policy == secretguardianv1alpha1.AdoptionPolicyAdoptAlways || (tagged && policy == secretguardianv1alpha1.AdoptionPolicyAdoptIfTagged) {
+		return true, nil
+	}
+	if tagged {
+		return false, fmt.Errorf("%w: secret %s in the store is owned by %s, set adoptionPolicy to AdoptIfTagged to adopt it", ErrAdoptionRefused, awsSecretGuardian.Spec.SecretName(), owner)
+	}
+	return false, fmt.Errorf("%w: secret %s exists in the store and was not created by the controller, set adoptionPolicy to AdoptAlways to adopt it", ErrAdoptionRefused, awsSecretGuardian.Spec.SecretName())
+}
+
+// function to check if the guardian may write the existing k8s secret
+// the secret is owned if it is annotated for the guardian or controlled by it, a secret with the rotation annotation
+// but without the owner annotation was written before the owner annotation existed
+// other secrets are adopted according to the adoption policy: secrets annotated for another guardian with AdoptIfTagged,
+// every secret with AdoptAlways
+// return nil if the secret does not exist or may be written, an error wrapping ErrAdoptionRefused otherwise
+func CheckK8SOwnership(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, secretObj *corev1.Secret) error {
+	if secretObj == nil {
+		return nil
+	}
+	owner, annotated := secretObj.Annotations[OwnerAnnotation]
+	if owner == GuardianOwner(awsSecretGuardian) || metav1.IsControlledBy(secretObj, awsSecretGuardian) {
+		return nil
+	}
+	if _, rotated := secretObj.Annotations[RotationAnnotation]; rotated && !annotated {
+		return nil
+	}
+	policy := awsSecretGuardian.Spec.AdoptionPolicy
+	if policy == secretguardianv1alpha1.AdoptionPolicyAdoptAlways || (annotated && policy == secretguardianv1alpha1.AdoptionPolicyAdoptIfTagged) {
+		return nil
+	}
+	if annotated {
+		return fmt.Errorf("%w: Secret %s/%s is owned by %s, set adoptionPolicy to AdoptIfTagged to adopt it", ErrAdoptionRefused, secretObj.Namespace, secretObj.Name, owner)
+	}
+	return fmt.Errorf("%w: Secret %s/%s exists and was not created by the controller, set adoptionPolicy to AdoptAlways to adopt it", ErrAdoptionRefused, secretObj.Namespace, secretObj.Name)
+}
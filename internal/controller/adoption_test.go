@@ -0,0 +1,226 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/awsstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+// function to create a secret with a current value in the store, as created outside of the controller
+// return the store
+func newStoreWithSecret(t *testing.T, tags map[string]string) *fake.Store {
+	t.Helper()
+	ctx := context.Background()
+	store := fake.New()
+	version, err := store.Stage(ctx, "db-password", map[string]string{"username": "existing", "password": "existing"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Promote(ctx, "db-password", version.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, "db-password", tags); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestReconcileTagsCreatedSecrets(t *testing.T) {
+	store := fake.New()
+	r := newTestReconciler(t, store, newTestGuardian())
+
+	_, _, secret := reconcileGuardian(t, r)
+	if store.Secret("db-password").Tags[OwnerTag] != "default/guardian" || secret.Annotations[OwnerAnnotation] != "default/guardian" {
+		t.Errorf("store tags %v, secret annotations %v, want the owner of the guardian", store.Secret("db-password").Tags, secret.Annotations)
+	}
+}
+
+func TestAdoptionOfStoreSecret(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy secretguardianv1alpha1.AdoptionPolicy
+		tags   map[string]string
+		adopt  bool
+	}{
+		{"untagged with Fail", secretguardianv1alpha1.AdoptionPolicyFail, map[string]string{"team": "payments"}, false},
+		{"untagged with AdoptIfTagged", secretguardianv1alpha1.AdoptionPolicyAdoptIfTagged, map[string]string{}, false},
+		{"untagged with AdoptAlways", secretguardianv1alpha1.AdoptionPolicyAdoptAlways, map[string]string{}, true},
+		{"tagged with Fail", secretguardianv1alpha1.AdoptionPolicyFail, map[string]string{OwnerTag: "default/old"}, false},
+		{"tagged with AdoptIfTagged", secretguardianv1alpha1.AdoptionPolicyAdoptIfTagged, map[string]string{OwnerTag: "default/old"}, true},
+	}
+	for _, c := range cases {
+		store := newStoreWithSecret(t, c.tags)
+		guardian := newTestGuardian()
+		guardian.Spec.AdoptionPolicy = c.policy
+		r := newTestReconciler(t, store, guardian)
+
+		result, got, secret := reconcileGuardian(t, r)
+		adopted := store.Secret("db-password").Tags[OwnerTag] == "default/guardian"
+		if !c.adopt {
+			condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionReady)
+			if adopted || secret != nil || condition == nil || condition.Reason != ReasonAdoptionRefused || result.RequeueAfter == 0 {
+				t.Errorf("%s: adopted %v, secret %v, Ready condition %v, want the adoption refused", c.name, adopted, secret, condition)
+			}
+			if store.Current("db-password")["password"] != "existing" {
+				t.Errorf("%s: the value of a refused secret was overwritten", c.name)
+			}
+			continue
+		}
+		if !adopted || secret == nil || string(secret.Data["password"]) != store.Current("db-password")["password"] {
+			t.Errorf("%s: adopted %v, secret %v, want the secret adopted and in sync", c.name, adopted, secret)
+		}
+	}
+}
+
+func TestAdoptionOfK8SSecret(t *testing.T) {
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-password", Namespace: "default", Labels: map[string]string{"team": "payments"}},
+		Data:       map[string][]byte{"password": []byte("existing")},
+	}
+	store := fake.New()
+	r := newTestReconciler(t, store, newTestGuardian(), existing.DeepCopy())
+	result, got, _ := reconcileGuardian(t, r)
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionReady)
+	if condition == nil || condition.Reason != ReasonAdoptionRefused || result.RequeueAfter == 0 || store.Secret("db-password") != nil {
+		t.Errorf("Ready condition %v, want the adoption of an unannotated Secret refused", condition)
+	}
+
+	guardian := newTestGuardian()
+	guardian.Spec.AdoptionPolicy = secretguardianv1alpha1.AdoptionPolicyAdoptAlways
+	r = newTestReconciler(t, store, guardian, existing.DeepCopy())
+	_, got, secret := reconcileGuardian(t, r)
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, secretguardianv1alpha1.ConditionReady) {
+		t.Fatalf("conditions %v, want the Secret adopted", got.Status.Conditions)
+	}
+	if secret.Annotations[OwnerAnnotation] != "default/guardian" || string(secret.Data["password"]) == "existing" || secret.Labels["team"] != "payments" {
+		t.Errorf("adopted secret %v, want it annotated and rotated with its labels kept", secret)
+	}
+}
+
+func TestDeletionKeepsSecretNotOwned(t *testing.T) {
+	store := newStoreWithSecret(t, map[string]string{})
+	guardian := newTestGuardian()
+	guardian.Spec.DeletionPolicy = secretguardianv1alpha1.DeletionPolicyDelete
+	r := newTestReconciler(t, store, guardian)
+	reconcileGuardian(t, r) // the adoption is refused
+
+	if _, got := deleteGuardian(t, r); got != nil {
+		t.Fatalf("guardian kept its finalizers %v", got.Finalizers)
+	}
+	if store.Secret("db-password").Deleted {
+		t.Error("a secret not owned by the guardian was deleted from the store")
+	}
+}
+
+func TestOwnsStoreSecret(t *testing.T) {
+	guardian := newTestGuardian()
+	guardian.Status.AWSSecretARN = "fake:db-password"
+	cases := map[string]struct {
+		info secretstore.SecretInfo
+		owns bool
+	}{
+		"tagged for the guardian":         {secretstore.SecretInfo{ID: "fake:other", Tags: map[string]string{OwnerTag: "default/guardian"}}, true},
+		"untagged with the recorded id":   {secretstore.SecretInfo{ID: "fake:db-password", Tags: map[string]string{}}, true},
+		"untagged with another id":        {secretstore.SecretInfo{ID: "fake:other", Tags: map[string]string{}}, false},
+		"tagged for another guardian":     {secretstore.SecretInfo{ID: "fake:db-password", Tags: map[string]string{OwnerTag: "default/other"}}, false},
+		"untagged without an id recorded": {secretstore.SecretInfo{Tags: map[string]string{}}, false},
+	}
+	for name, c := range cases {
+		if owns := OwnsStoreSecret(guardian, &c.info); owns != c.owns {
+			t.Errorf("%s: owns %v, want %v", name, owns, c.owns)
+		}
+	}
+}
+
+func TestDeletionKeepsSecretAdoptedByAnotherGuardian(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.DeletionPolicy = secretguardianv1alpha1.DeletionPolicyDelete
+	other := newTestGuardian()
+	other.Name, other.UID = "other", "other-uid"
+	other.Spec.AdoptionPolicy = secretguardianv1alpha1.AdoptionPolicyAdoptIfTagged
+	other.Spec.Target = &secretguardianv1alpha1.SecretTarget{Name: "other-db-password"}
+	r := newTestReconciler(t, store, guardian, other)
+
+	_, created, _ := reconcileGuardian(t, r) // the guardian creates the secret and records its id
+	if created.Status.AWSSecretARN == "" {
+		t.Fatal("the id of the secret is not recorded in the status")
+	}
+	ctx := context.Background()
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "other"}}); err != nil {
+		t.Fatal(err)
+	}
+	if owner := store.Secret("db-password").Tags[OwnerTag]; owner != "default/other" {
+		t.Fatalf("owner tag %s, want the secret adopted by the other guardian", owner)
+	}
+
+	if _, got := deleteGuardian(t, r); got != nil {
+		t.Fatalf("guardian kept its finalizers %v", got.Finalizers)
+	}
+	if store.Secret("db-password").Deleted {
+		t.Error("the secret adopted by another guardian was deleted from the store")
+	}
+}
+
+func TestReconcileResumesInterruptedCreation(t *testing.T) {
+	store := fake.New()
+	store.Errors["Promote"] = errors.New("promote failed") // the reconcile stops after the secret is created
+	store.Errors["Tag"] = errors.New("tag failed")         // a separate tag call would leave the secret untagged
+	r := newTestReconciler(t, store, newTestGuardian())
+	reconcileGuardian(t, r)
+	if store.Secret("db-password").Tags[OwnerTag] != "default/guardian" {
+		t.Fatalf("store tags %v, want the secret created with the owner tag", store.Secret("db-password").Tags)
+	}
+
+	delete(store.Errors, "Promote")
+	delete(store.Errors, "Tag")
+	_, got, secret := reconcileGuardian(t, r)
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, secretguardianv1alpha1.ConditionReady) {
+		t.Errorf("conditions %v, want the guardian to resume its own secret", got.Status.Conditions)
+	}
+	if secret == nil || string(secret.Data["password"]) != store.Current("db-password")["password"] {
+		t.Errorf("secret %v, want it in sync with the store", secret)
+	}
+}
+
+func TestReconcileRecognizesLegacySecret(t *testing.T) {
+	store := newStoreWithSecret(t, map[string]string{})
+	store.Secret("db-password").Description = awsstore.Description // created by a controller without owner tags and status
+	guardian := newTestGuardian()                                  // an empty status, the policy is Fail
+	r := newTestReconciler(t, store, guardian)
+
+	_, got, secret := reconcileGuardian(t, r)
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, secretguardianv1alpha1.ConditionReady) || secret == nil {
+		t.Fatalf("conditions %v, want the legacy secret recognized", got.Status.Conditions)
+	}
+	if store.Secret("db-password").Tags[OwnerTag] != "default/guardian" {
+		t.Errorf("store tags %v, want the legacy secret tagged for the guardian", store.Secret("db-password").Tags)
+	}
+}
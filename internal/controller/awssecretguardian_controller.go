@@ -19,238 +19,868 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"reflect"
+	"sort"
+	"strings"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	log "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
-	"math/rand"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/secretsmanager"
-	"github.com/aws/aws-sdk-go/service/sts"
 	corev1 "k8s.io/api/core/v1"
 
 	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/awsstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/vaultstore"
 )
 
 // AWSSecretGuardianReconciler reconciles a AWSSecretGuardian object
 type AWSSecretGuardianReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// DefaultCredentialsSecret is the credentials Secret used by guardians without a credentialsRef
+	DefaultCredentialsSecret types.NamespacedName
+
+	// AllowCrossNamespaceTargets allows guardians to write their Secret to another namespace with target.namespace
+	AllowCrossNamespaceTargets bool
+
+	// AllowControllerIdentity allows guardians to use the AWS identity of the controller pod
+	// with the Default, WebIdentity and PodIdentity auth types
+	AllowControllerIdentity bool
+
+	// VaultAddresses are the Vault servers guardians may use, no server is allowed if empty
+	VaultAddresses []string
+
+	// AllowVaultKubernetesAuth allows guardians to log in to the servers of VaultAddresses
+	// with the service account token of the controller
+	AllowVaultKubernetesAuth bool
+
+	// VaultKubernetesTokenFile is the service account token used by the Vault Kubernetes method,
+	// defaults to DefaultVaultKubernetesTokenFile
+	VaultKubernetesTokenFile string
+
+	// NewSecretStore returns the secret store of a guardian, defaults to the AWS Secret Manager store
+	NewSecretStore func(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (secretstore.SecretStore, error)
+
+	// Now is the clock of the rotations, defaults to time.Now
+	Now func() time.Time
+
+	awsClients  awsstore.ClientCache  // AWS clients by region and identity, reused between reconciles
+	vaultTokens vaultstore.TokenCache // Vault tokens by guardian, reused between reconciles
 }
 
+// RequeueAfterTime is the retry interval in seconds after a failed reconcile
 var RequeueAfterTime time.Duration = 5
 var RequeueAfterTimeKeys time.Duration = 10
 var logger = log.Log.WithName("awssecretguardian-controller")
 
-// +kubebuilder:rbac:groups=secretguardian.k8s.io,resources=awssecretguardians,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=secretguardian.k8s.io,resources=awssecretguardians/status,verbs=get;update;patch
+// Annotations set by the controller on the k8s secrets it manages
+const (
+	// RotationAnnotation holds the time of the last rotation in RFC3339
+	RotationAnnotation = "K8s-Secret-Rotation-Controller"
+	// VersionIDAnnotation holds the store version id of the value stored in the secret
+	VersionIDAnnotation = "K8s-Secret-Rotation-Controller-Version-Id"
+)
+
+// FieldManager is the field manager of the k8s secrets and ConfigMaps applied by the controller
+const FieldManager = "k8s-secret-rotation-controller"
+
+// ErrInvalidKeys is returned when the keys of a guardian can not be generated as specified
+var ErrInvalidKeys = errors.New("invalid keys")
+
+// RotationResult holds the outcome of a SecretHandler call
+type RotationResult struct {
+	ARN              string    // id of the secret in the store, the ARN in the AWS Secret Manager
+	VersionID        string    // version id of the current value
+	LastRotationTime time.Time // time of the last successful rotation, zero if unknown
+	RefreshTime      time.Time // time a certificate is renewed or a JWKS pruned, zero if the value only changes with the TTL
+	Rotated          bool      // true if the secret was rotated by this call
+}
+
+//+kubebuilder:rbac:groups=secretguardian.omerap12.com,resources=awssecretguardians,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=secretguardian.omerap12.com,resources=awssecretguardians/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=secretguardian.omerap12.com,resources=awssecretguardians/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
+
+// Reconcile rotates the secret of a single AWSSecretGuardian when its TTL is reached
 func (r *AWSSecretGuardianReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) { //
-	access_key, secret_key, err := r.GetCreds(ctx, "awssecretguardian", "aws-creds") // get the access key and secret key from the secret in the namespace awssecretguardian
+	awsSecretGuardian := &secretguardianv1alpha1.AWSSecretGuardian{} // create the AWSSecretGuardian object of the request
+	err := r.Get(ctx, req.NamespacedName, awsSecretGuardian)         // get only the requested AWSSecretGuardian object
 	if err != nil {
-		logger.Error(err, "Error getting access-key and secret-access-key from the secret in the namespace awssecretguardian (value may be null)")
-		return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
+		if !apierrors.IsNotFound(err) {
+			logger.Info(fmt.Sprintf("Error getting AWSSecretGuardian %s: %s", req.NamespacedName, err))
+		} else {
+			r.awsClients.InvalidateOwner(req.NamespacedName.String()) // the assumed role of a deleted guardian must not be reused
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	if access_key == "" || secret_key == "" {
-		logger.Info("access-key or secret-access-key is empty")
-		return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+	if !awsSecretGuardian.DeletionTimestamp.IsZero() { // the guardian is being deleted
+		return r.FinalizeGuardian(ctx, awsSecretGuardian)
+	}
+	if err := r.EnsureFinalizer(ctx, awsSecretGuardian); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	store, err := r.SecretStoreFor(ctx, awsSecretGuardian) // get the secret store of the guardian according to its auth type
+	if err != nil {
+		logger.Info(fmt.Sprintf("Error getting the AWS credentials of AWSSecretGuardian %s: %s", req.NamespacedName, err))
+		if errors.Is(err, ErrCredentialsRefForbidden) { // retrying does not help until the spec is changed
+			r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonCredentialsRefForbidden, err.Error())
+			return ctrl.Result{}, nil
+		}
+		if errors.Is(err, ErrAuthTypeForbidden) {
+			r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonAuthTypeForbidden, err.Error())
+			return ctrl.Result{}, nil
+		}
+		if errors.Is(err, ErrVaultForbidden) {
+			r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonVaultForbidden, err.Error())
+			return ctrl.Result{}, nil
+		}
+		if errors.Is(err, ErrAssumeRoleFailed) {
+			r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonAssumeRoleFailed, err.Error())
+			return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
+		}
+		r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonCredentialsNotFound, err.Error())
+		return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
 	}
 
-	userARN, err := r.GetUserARN("us-east-1", access_key, secret_key) // get the ARN of the user using the AWS STS service
+	userARN, err := store.Identity(ctx) // get the ARN of the user using the AWS STS service, or the Vault token name
 	if err != nil {
 		logger.Info(fmt.Sprintf("Error getting user ARN: %s", err))
+		r.vaultTokens.Invalidate(req.NamespacedName.String()) // a revoked Vault token is replaced on the next reconcile
+		r.SetAWSUnreachable(ctx, awsSecretGuardian, ReasonAWSAuthFailed, err.Error())
 		return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
 	}
 	logger.Info(fmt.Sprintf("User ARN: %s", userARN))
-	awsSecretGuardiansList := &secretguardianv1alpha1.AWSSecretGuardianList{} // create the list object of all the AWSSecretGuardian objects
-	err = r.List(ctx, awsSecretGuardiansList)                                 // get all the AWSSecretGuardian objects in the cluster
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionAWSReachable, metav1.ConditionTrue, ReasonAuthenticated, fmt.Sprintf("Authenticated as %s", userARN))
+
+	// get the secret name and TTL from the AWSSecretGuardian object
+	secretName, ttl := awsSecretGuardian.Spec.SecretName(), awsSecretGuardian.Spec.TTL
+	result, err := r.SecretHandler(ctx, store, awsSecretGuardian) // create or update the secret in the AWS Secret Manager and in the k8s cluster
 	if err != nil {
-		logger.Info(fmt.Sprintf("Error getting the list of AWSSecretGuardian objects: %s", err))
-		return ctrl.Result{}, client.IgnoreNotFound(err)
-	}
-	for _, awsSecretGuardian := range awsSecretGuardiansList.Items { // iterate over all the AWSSecretGuardian objects
-		region, secretName, length, ttl, keys, nameSpace := awsSecretGuardian.Spec.Region, awsSecretGuardian.Spec.Name, awsSecretGuardian.Spec.Length, awsSecretGuardian.Spec.TTL, awsSecretGuardian.Spec.Keys, awsSecretGuardian.ObjectMeta.Namespace // get the region, secret name, length, TTL, keys and namespace from the AWSSecretGuardian object
-		secretExist, err := r.CheckAWSSecretExist(region, access_key, secret_key, secretName)                                                                                                                                                          // check if the secret already exists in the AWS Secret Manager
-		if err != nil {
-			logger.Info(fmt.Sprintf("Error checking if the secret exists in the AWS Secret Manager: %s", err))
-			return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+		logger.Info(fmt.Sprintf("Error creating or updating the secret in the AWS Secret Manager: %s", err))
+		if errors.Is(err, ErrAdoptionRefused) { // the secret may be tagged or deleted outside of the cluster, retry slowly
+			SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionFalse, ReasonAdoptionRefused, err.Error())
+			SetFailed(awsSecretGuardian, ReasonAdoptionRefused, err.Error())
+			r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+			return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
 		}
-		ok, err := r.SecretHandler(ctx, region, access_key, secret_key, nameSpace, ttl, secretName, keys, length, secretExist) // create or update the secret in the AWS Secret Manager
-		if err != nil {
-			logger.Info(fmt.Sprintf("Error creating or updating the secret in the AWS Secret Manager: %s", err))
-			return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+		if errors.Is(err, ErrK8SSecretConflict) { // retry slowly until the other field manager releases the fields
+			SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionFalse, ReasonK8SSecretConflict, err.Error())
+			SetFailed(awsSecretGuardian, ReasonK8SSecretConflict, err.Error())
+			r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+			return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
 		}
-		if ok {
-			logger.Info(fmt.Sprintf("Secret %s created or updated in the AWS Secret Manager", secretName))
-		} else {
-			logger.Info(fmt.Sprintf("Secret %s TTL not reached", secretName))
+		if errors.Is(err, ErrIssuerExpiring) { // retry slowly until the issuer is renewed
+			SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionFalse, ReasonIssuerExpiring, err.Error())
+			SetFailed(awsSecretGuardian, ReasonIssuerExpiring, err.Error())
+			r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+			return ctrl.Result{RequeueAfter: RequeueAfterTimeKeys * time.Second}, nil
+		}
+		if reason := InvalidSpecReason(err); reason != "" { // retrying does not help until the spec is changed
+			SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionFalse, reason, err.Error())
+			SetFailed(awsSecretGuardian, reason, err.Error())
+			r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+			return ctrl.Result{}, nil
 		}
+		SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionFalse, ReasonRotationFailed, err.Error())
+		SetFailed(awsSecretGuardian, ReasonRotationFailed, err.Error())
+		r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+		return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+	}
+	awsSecretGuardian.Status.AWSSecretARN = result.ARN
+	awsSecretGuardian.Status.AWSVersionID = result.VersionID
+	if !result.LastRotationTime.IsZero() {
+		lastRotationTime := metav1.NewTime(result.LastRotationTime)
+		awsSecretGuardian.Status.LastRotationTime = &lastRotationTime
+	}
+	if result.Rotated {
+		logger.Info(fmt.Sprintf("Secret %s created or updated in the AWS Secret Manager", secretName))
+		SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionRotated, metav1.ConditionTrue, ReasonRotationSucceeded, fmt.Sprintf("Secret %s rotated", secretName))
+	} else {
+		logger.Info(fmt.Sprintf("Secret %s TTL not reached", secretName))
 	}
-	return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+	if awsSecretGuardian.Status.LastRotationTime != nil {
+		next := metav1.NewTime(awsSecretGuardian.Status.LastRotationTime.Add(time.Second * time.Duration(ttl)))
+		if !result.RefreshTime.IsZero() && result.RefreshTime.Before(next.Time) { // a certificate expires or a JWKS key is pruned before the TTL
+			next = metav1.NewTime(result.RefreshTime)
+		}
+		awsSecretGuardian.Status.NextRotationTime = &next
+	}
+	SetSucceeded(awsSecretGuardian)
+	r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+	return ctrl.Result{RequeueAfter: NextRequeue(awsSecretGuardian, r.now())}, nil
+}
+
+// function to get the time of the clock of the reconciler
+// return the current time in UTC
+func (r *AWSSecretGuardianReconciler) now() time.Time {
+	if r.Now == nil {
+		return time.Now().UTC()
+	}
+	return r.Now().UTC()
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// Status updates do not change the generation, so they do not trigger a new reconcile.
+// Changes to a credentials Secret reconcile the guardians using it.
+// Changes to a Secret or a JWKS ConfigMap owned by a guardian, e.g. a manual edit or deletion, reconcile the guardian.
 func (r *AWSSecretGuardianReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&secretguardianv1alpha1.AWSSecretGuardian{}).
+		For(&secretguardianv1alpha1.AWSSecretGuardian{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.GuardiansForCredentialsSecret)).
 		Complete(r)
 }
 
-// function to get the access key and secret key from the secret
-// return the access key and secret key as strings
-func (r *AWSSecretGuardianReconciler) GetCreds(ctx context.Context, nameSpaceName string, secretName string) (string, string, error) {
-	secret := &corev1.Secret{}
-	err := r.Get(ctx, client.ObjectKey{Name: secretName, Namespace: nameSpaceName}, secret)
-	if err != nil {
-		return "", "", err
+// function to compute when the AWSSecretGuardian needs to be reconciled again
+// return the time left until the next rotation, or RequeueAfterTime if the next rotation is unknown
+func NextRequeue(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, now time.Time) time.Duration {
+	if awsSecretGuardian.Status.NextRotationTime == nil {
+		return RequeueAfterTime * time.Second
+	}
+	requeueAfter := awsSecretGuardian.Status.NextRotationTime.Sub(now)
+	if requeueAfter < time.Second { // the rotation is already due
+		return time.Second
 	}
-	access_key := string(secret.Data["access-key"])
-	secret_key := string(secret.Data["secret-access-key"])
-	return access_key, secret_key, nil
+	return requeueAfter
 }
 
-// function to get the ARN of the user using the AWS STS service
-// return the ARN of the user as a string
-func (r *AWSSecretGuardianReconciler) GetUserARN(region string, access_key string, secret_access_key string) (string, error) {
-	os.Setenv("AWS_ACCESS_KEY_ID", access_key)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", secret_access_key)
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
-	// Create a new AWS STS client
-	svc := sts.New(sess)
-	result, err := svc.GetCallerIdentity(nil)
+// function to get the secret store of the AWSSecretGuardian
+// return the store built by NewSecretStore if set, the store of spec.provider otherwise
+func (r *AWSSecretGuardianReconciler) SecretStoreFor(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (secretstore.SecretStore, error) {
+	if r.NewSecretStore != nil {
+		return r.NewSecretStore(ctx, awsSecretGuardian)
+	}
+	if awsSecretGuardian.Spec.Provider == secretguardianv1alpha1.ProviderVault {
+		return r.VaultStoreFor(ctx, awsSecretGuardian)
+	}
+	clients, err := r.AWSClientsFor(ctx, awsSecretGuardian) // get the AWS clients of the guardian according to its auth type
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return *result.Arn, nil
+	return awsstore.New(clients), nil
 }
 
-// function to check if the secret already exists in the AWS Secret Manager
-// return true if the secret exists, false if the secret does not exist
-func (r *AWSSecretGuardianReconciler) CheckAWSSecretExist(region string, access_key string, secret_access_key string, secretName string) (bool, error) {
-	os.Setenv("AWS_ACCESS_KEY_ID", access_key)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", secret_access_key)
-	sess := session.Must(session.NewSession(&aws.Config{ // create a new session
-		Region: aws.String(region),
-	}))
-
-	svc := secretsmanager.New(sess)             // create a new AWS Secret Manager client
-	input := &secretsmanager.ListSecretsInput{} // create a new input object
-	result, err := svc.ListSecrets(input)       // list all the secrets in the AWS Secret Manager
+// function to create or update the secret in the secret store and in the k8s cluster
+// the rotation decision is made once, before anything is written
+// a new value is staged in the store, written to the k8s secret and only then promoted to current
+// if a previous rotation was interrupted, the staged value is reused instead of generating a new one
+// keys that do not rotate keep their current value, a deleted k8s secret is restored with the current value
+// between rotations, the keys added or changed in the spec are regenerated on their own, see StaleKeys,
+// and the value is refreshed without changing the rotation time, see RefreshedValue
+// the k8s secret is written to the target of the guardian, see TargetFor, and owned by the guardian unless it is orphaned
+// secrets the guardian did not create are only written if its adoption policy allows it, see CheckStoreOwnership
+// return the rotation result, the result is not nil when err is nil
+func (r *AWSSecretGuardianReconciler) SecretHandler(ctx context.Context, store secretstore.SecretStore, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (*RotationResult, error) {
+	nameSpaceName, spec := awsSecretGuardian.Namespace, &awsSecretGuardian.Spec
+	secretName := spec.SecretName()
+	target, err := r.TargetFor(nameSpaceName, spec) // the k8s secret written for the store secret
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	target.Owner, target.OwnerName = OwnerReferenceFor(awsSecretGuardian, target), GuardianOwner(awsSecretGuardian)
+	secretObj, err := r.GetSecretK8S(ctx, target.Namespace, target.Name) // get the secret object from the k8s cluster, nil if not found
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
 	}
-	secretsList := result.SecretList
-	for _, value := range secretsList {
-		if secretName == *value.Name {
-			return true, nil
+	if err := CheckK8SOwnership(awsSecretGuardian, secretObj); err != nil {
+		return nil, err
+	}
+	info, err := store.Describe(ctx, secretName) // get the current and pending versions of the secret
+	if errors.Is(err, secretstore.ErrNotFound) {
+		info = &secretstore.SecretInfo{} // the secret is created when its first value is staged
+	} else if err != nil {
+		return nil, err
+	}
+	if info.ID != "" {
+		tag, err := CheckStoreOwnership(awsSecretGuardian, info)
+		if err != nil {
+			return nil, err
+		}
+		if tag {
+			logger.Info(fmt.Sprintf("Adopting secret %s in the store", secretName))
+			if err := store.Tag(ctx, secretName, map[string]string{OwnerTag: target.OwnerName}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	result := &RotationResult{ARN: info.ID, VersionID: info.CurrentVersionID}
+
+	var value map[string]string
+	pendingVersionID := info.PendingVersionID
+	now := r.now()
+	rotationTime, rotated := now, true
+	if pendingVersionID != "" { // a previous rotation staged a value but did not promote it
+		logger.Info(fmt.Sprintf("Resuming interrupted rotation of secret %s (version %s)", secretName, pendingVersionID))
+		value, err = store.Get(ctx, secretName, pendingVersionID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lastRotationTime, rotate, err := RotationDue(secretObj, target.Type, spec.TTL, now)
+		if err != nil {
+			return nil, err
+		}
+		if secretObj == nil && result.VersionID != "" && awsSecretGuardian.Status.LastRotationTime != nil {
+			// the k8s secret was deleted, it is restored with the current value of the store until the TTL is reached
+			lastRotationTime = awsSecretGuardian.Status.LastRotationTime.UTC()
+			rotate = now.After(lastRotationTime.Add(time.Second * time.Duration(spec.TTL)))
+			if !rotate {
+				logger.Info(fmt.Sprintf("Restoring secret %s/%s from the current value of secret %s", target.Namespace, target.Name, secretName))
+			}
+		}
+		result.LastRotationTime = lastRotationTime
+		if !rotate && result.VersionID != "" {
+			current, err := store.Get(ctx, secretName, info.CurrentVersionID)
+			if err != nil {
+				return nil, err
+			}
+			if stale := StaleKeys(spec.Keys, current, now); len(stale) > 0 { // e.g. a new key, a changed fixed value or a certificate to renew
+				logger.Info(fmt.Sprintf("Regenerating keys %s of secret %s without rotating the other keys", strings.Join(SortedNames(stale), ", "), secretName))
+				value, err = r.GeneratePassword(ctx, nameSpaceName, spec, current, stale)
+			} else {
+				value, err = RefreshedValue(spec, current, now)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if value == nil { // nothing to do, the store and k8s hold the same value
+				if err := r.JWKSConfigMapHandler(ctx, awsSecretGuardian, current); err != nil { // e.g. a deleted ConfigMap
+					return nil, err
+				}
+				if err := r.SyncK8SSecret(ctx, target, current, info.CurrentVersionID, lastRotationTime); err != nil { // e.g. new labels or a tampered key
+					return nil, err
+				}
+				result.RefreshTime = RefreshTime(spec.Keys, K8SSecretData(current), now)
+				return result, nil
+			}
+			logger.Info(fmt.Sprintf("Refreshing secret %s without rotating it", secretName))
+			rotationTime, rotated = lastRotationTime, false // the value is not rotated, the TTL of its keys is kept
+		} else {
+			previous, err := r.PreviousValue(ctx, store, secretName, info.CurrentVersionID, spec.Keys)
+			if err != nil {
+				return nil, err
+			}
+			value, err = r.GeneratePassword(ctx, nameSpaceName, spec, previous, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		version, err := store.Stage(ctx, secretName, value, map[string]string{OwnerTag: target.OwnerName}) // stage the new value, the current value is not changed, a new secret is created with the owner tag
+		if err != nil {
+			return nil, err
 		}
+		result.ARN, pendingVersionID = version.ID, version.VersionID
 	}
-	return false, nil
+
+	err = r.JWKSConfigMapHandler(ctx, awsSecretGuardian, value) // publish the new public keys before the new private keys are used
+	if err != nil {
+		return nil, err
+	}
+	secretData, err := TargetData(target, K8SSecretData(value))
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.K8SSecretHandler(ctx, target, secretData, pendingVersionID, rotationTime) // persist the new value in the k8s cluster
+	if err != nil {
+		return nil, err
+	}
+	err = store.Promote(ctx, secretName, pendingVersionID) // the k8s secret holds the new value, make it current in the store
+	if err != nil {
+		return nil, err
+	}
+	result.VersionID = pendingVersionID
+	result.LastRotationTime = rotationTime
+	result.RefreshTime = RefreshTime(spec.Keys, K8SSecretData(value), now)
+	result.Rotated = rotated
+	return result, nil
 }
 
-// function to create or update the secret in the AWS Secret Manager
-// if the secret already exists, it will update the secret with a new password
-// if the secret does not exist, it will create a new secret with a new password
-// return true if the secret is created or updated successfully
-func (r *AWSSecretGuardianReconciler) SecretHandler(ctx context.Context, region string, access_key string, secret_access_key string, nameSpaceName string, ttl int, secretName string, keys []string, length int, secretExist bool) (bool, error) {
-	os.Setenv("AWS_ACCESS_KEY_ID", access_key)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", secret_access_key)
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
-	svc := secretsmanager.New(sess)
-	password, k8sSecretData, err := r.GeneratePassword(keys, length)
+// function to decide if the secret needs to be rotated
+// the secret is rotated if it does not exist in the k8s cluster, if its TTL has been reached or if the type of the secret changed
+// an adopted secret without the rotation annotation is rotated right away,
+// keys added or changed in the spec and certificates due for renewal are regenerated on their own, see StaleKeys
+// return the time of the last rotation and true if the secret needs to be rotated
+func RotationDue(secretObj *corev1.Secret, secretType corev1.SecretType, ttl int, now time.Time) (time.Time, bool, error) {
+	if secretObj == nil { // the secret does not exist in the k8s cluster
+		return time.Time{}, true, nil
+	}
+	if _, ok := secretObj.Annotations[RotationAnnotation]; !ok {
+		return time.Time{}, true, nil
+	}
+	annotationTime, err := time.Parse(time.RFC3339, secretObj.Annotations[RotationAnnotation]) // get the annotation time from the secret object
 	if err != nil {
-		return false, err
+		return time.Time{}, false, err
+	}
+	if secretObj.Type != secretType && (secretObj.Type != "" || secretType != corev1.SecretTypeOpaque) {
+		return annotationTime, true, nil
 	}
-	if secretExist {
-		input := &secretsmanager.UpdateSecretInput{ // create a new input object
-			SecretId:     aws.String(secretName),
-			Description:  aws.String("Secret Managed By AWSGuardian"),
-			SecretString: aws.String(password),
+	if !now.After(annotationTime.Add(time.Second * time.Duration(ttl))) { // check if the TTL of the secret has reached
+		return annotationTime, false, nil
+	}
+	return annotationTime, true, nil
+}
+
+// function to find the keys whose current value no longer matches the spec
+// a key is stale if one of its keys is missing from the value, e.g. a new key, if its fixed value has changed
+// or if it is a certificate due for renewal, a missing derived key, e.g. a new hash, is computed from the value instead
+// return the names of the stale keys of spec.keys, nil if none
+func StaleKeys(keys []secretguardianv1alpha1.KeySpec, current map[string]string, now time.Time) map[string]bool {
+	var stale map[string]bool
+	for _, key := range keys {
+		isStale := key.Value != nil && current[key.Name] != *key.Value
+		if renewalTime := CertificateRenewalTime([]secretguardianv1alpha1.KeySpec{key}, K8SSecretData(current), now); !renewalTime.IsZero() && !now.Before(renewalTime) {
+			isStale = true
 		}
-		_, err := svc.UpdateSecret(input) // update the secret in the AWS Secret Manager
-		if err != nil {
-			return false, err
+		derived := DerivedKeys(&key)
+		for _, name := range key.SecretKeys() {
+			if _, ok := current[name]; !ok && !derived[name] {
+				isStale = true
+			}
 		}
-	} else {
-		input := &secretsmanager.CreateSecretInput{ // create a new input object
-			Description:  aws.String("Secret Managed By AWSGuardian"),
-			Name:         aws.String(secretName),
-			SecretString: aws.String(password),
+		if isStale {
+			if stale == nil {
+				stale = map[string]bool{}
+			}
+			stale[key.Name] = true
+		}
+	}
+	return stale
+}
+
+// function to list the names of a set in a stable order, e.g. for the logs
+// return the sorted names
+func SortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// function to compute when the value must change before its TTL
+// return the earliest certificate renewal or JWKS prune time, zero if there is none
+func RefreshTime(keys []secretguardianv1alpha1.KeySpec, data map[string][]byte, now time.Time) time.Time {
+	refreshTime := CertificateRenewalTime(keys, data, now)
+	if pruneTime := JWKSPruneTime(keys, data); !pruneTime.IsZero() && (refreshTime.IsZero() || pruneTime.Before(refreshTime)) {
+		refreshTime = pruneTime
+	}
+	return refreshTime
+}
+
+// function to get the current value of the secret refreshed without rotating it
+// the missing derived keys, e.g. a new hash, are computed from the current value,
+// the expired previous keys of the JWKS are pruned, the template is rendered again
+// and the keys the guardian no longer manages, e.g. the keys of a removed template, are dropped
+// return the refreshed value, or nil if nothing changed
+func RefreshedValue(spec *secretguardianv1alpha1.AWSSecretGuardianSpec, current map[string]string, now time.Time) (map[string]string, error) {
+	value := make(map[string]string, len(current))
+	for _, key := range spec.Keys { // only the keys of spec.keys are kept, the rendered keys are rendered again
+		for _, name := range key.SecretKeys() {
+			if v, ok := current[name]; ok {
+				value[name] = v
+			}
 		}
-		_, err := svc.CreateSecret(input) // create the secret in the AWS Secret Manager
+	}
+	for i := range spec.Keys {
+		if err := CompleteKeyValues(&spec.Keys[i], value, now); err != nil {
+			return nil, fmt.Errorf("key %s: %w", spec.Keys[i].Name, err)
+		}
+	}
+	for i := range spec.Keys { // once every value is complete, an htpasswd line may read the username of another key
+		hashes, err := HashValues(&spec.Keys[i], value, current)
 		if err != nil {
-			return false, err
+			return nil, fmt.Errorf("key %s: %w", spec.Keys[i].Name, err)
 		}
+		for name, v := range hashes {
+			value[name] = v
+		}
+	}
+	if _, err := PruneJWKSValue(spec.Keys, value, now); err != nil {
+		return nil, err
 	}
-	ok, err := r.K8SSecretHandler(ctx, nameSpaceName, ttl, secretName, k8sSecretData)
+	rendered, err := RenderTemplate(spec.Template, value)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	if !ok {
-		return false, nil
+	for name, v := range rendered {
+		value[name] = v
 	}
-	return true, nil
+	if reflect.DeepEqual(value, current) {
+		return nil, nil
+	}
+	return value, nil
 }
 
-// Function used to generate a random password of length n
-// The password will be a mix of uppercase, lowercase, numbers and special characters
-// return the password as a string and the password as a map of keys and values as a byte array for the k8s secret
-func (r *AWSSecretGuardianReconciler) GeneratePassword(keys []string, length int) (string, map[string][]byte, error) {
-	k8sSecretData := make(map[string][]byte, len(keys))
-	keyValueObject := make(map[string]string, len(keys))
-	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?~"
+// function to get the current value of the secret when some keys do not rotate or keep a JWKS
+// return the current value, or nil if every key rotates or the secret has no current value
+func (r *AWSSecretGuardianReconciler) PreviousValue(ctx context.Context, store secretstore.SecretStore, secretName string, currentVersionID string, keys []secretguardianv1alpha1.KeySpec) (map[string]string, error) {
+	if currentVersionID == "" {
+		return nil, nil
+	}
 	for _, key := range keys {
-		password := make([]byte, length)
-		for i := range password {
-			password[i] = charset[rand.Intn(len(charset))]
+		if (key.Value == nil && !key.Rotates()) || key.JWKSName() != "" {
+			value, err := store.Get(ctx, secretName, currentVersionID)
+			if errors.Is(err, secretstore.ErrNotFound) {
+				return nil, nil
+			}
+			return value, err
+		}
+	}
+	return nil, nil
+}
+
+// function to convert the value of a secret to the data of a k8s secret
+// return the value as a map of keys and values as a byte array
+func K8SSecretData(value map[string]string) map[string][]byte {
+	k8sSecretData := make(map[string][]byte, len(value))
+	for key, val := range value {
+		k8sSecretData[key] = []byte(val)
+	}
+	return k8sSecretData
+}
+
+// Function used to generate the values of the keys of the secret
+// every key is generated with its generator, passwords by default, from crypto/rand
+// fixed values are used as is and keys that do not rotate keep their previous value,
+// with a regenerate set only those keys are generated and the other keys keep their previous value
+// certificates signed by a CA are issued by the CA Secret of their issuerRef in the namespace, only read when they are generated
+// the hashes of a key are computed from its value, or kept from the previous value while it is unchanged,
+// the keys of spec.template are rendered last
+// return the values as a map of keys and values
+func (r *AWSSecretGuardianReconciler) GeneratePassword(ctx context.Context, nameSpaceName string, spec *secretguardianv1alpha1.AWSSecretGuardianSpec, previous map[string]string, regenerate map[string]bool) (map[string]string, error) {
+	keyValueObject, now := make(map[string]string, len(spec.Keys)), r.now()
+	var hashed []*secretguardianv1alpha1.KeySpec // keys whose hashes are computed once every value is known
+	for i := range spec.Keys {
+		key := &spec.Keys[i]
+		issuer := func() (*generator.Issuer, error) { return r.CertificateIssuer(ctx, nameSpaceName, key) }
+		values, err := KeyValues(spec, key, previous, issuer, regenerate, now)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.Name, err)
+		}
+		if len(key.Hashes) > 0 {
+			hashed = append(hashed, key)
+		}
+		for name, value := range values {
+			if _, ok := keyValueObject[name]; ok { // e.g. the public key of a key pair named like another key
+				return nil, fmt.Errorf("key %s: %w: %s is written by more than one key", key.Name, ErrInvalidKeys, name)
+			}
+			keyValueObject[name] = value
 		}
-		k8sSecretData[key] = password
-		pass := string(password)
-		keyValueObject[key] = pass
 	}
-	jsonString, err := json.Marshal(keyValueObject)
+	for _, key := range hashed {
+		hashes, err := HashValues(key, keyValueObject, previous)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.Name, err)
+		}
+		for name, value := range hashes {
+			if _, ok := keyValueObject[name]; ok {
+				return nil, fmt.Errorf("key %s: %w: %s is written by more than one key", key.Name, ErrInvalidKeys, name)
+			}
+			keyValueObject[name] = value
+		}
+	}
+	rendered, err := RenderTemplate(spec.Template, keyValueObject) // the template sees the values and hashes of every key
 	if err != nil {
-		return "", nil, err
+		return nil, err
+	}
+	for name, value := range rendered {
+		keyValueObject[name] = value
 	}
-	return string(jsonString), k8sSecretData, nil
+	return keyValueObject, nil
 }
 
-// function to create or update the secret in the k8s cluster
-// if the secret already exists, it will update the secret with a new password
-// if the secret does not exist, it will create a new secret with a new password
-// return true if the secret is created or updated successfully
-func (r *AWSSecretGuardianReconciler) K8SSecretHandler(ctx context.Context, nameSpaceName string, ttl int, secretName string, secretData map[string][]byte) (bool, error) {
-	secretObj, err := r.GetSecretK8S(ctx, nameSpaceName, secretName) // get the secret object from the k8s cluster
+// function to get the values written for a key, without its hashes, see HashValues
+// a fixed value is used as is, a key that does not rotate, or is not in a non nil regenerate set,
+// keeps its previous values if none but derived keys are missing and, for a certificate, if it is not due for renewal,
+// the missing derived keys are computed from the kept value, see CompleteKeyValues
+// the issuer of a certificate is only loaded when the certificate is generated
+// return the values by key of the secret
+func KeyValues(spec *secretguardianv1alpha1.AWSSecretGuardianSpec, key *secretguardianv1alpha1.KeySpec, previous map[string]string, issuerFor func() (*generator.Issuer, error), regenerate map[string]bool, now time.Time) (map[string]string, error) {
+	if key.Value != nil {
+		return map[string]string{key.Name: *key.Value}, nil
+	}
+	keep := !key.Rotates() || (regenerate != nil && !regenerate[key.Name])
+	if keep && previous != nil {
+		values, complete := map[string]string{}, true
+		derived := DerivedKeys(key)
+		hashes := HashNames(key)
+		for _, name := range key.SecretKeys() {
+			if value, ok := previous[name]; ok && !hashes[name] {
+				values[name] = value
+			} else if !ok && !derived[name] {
+				complete = false
+			}
+		}
+		renewalTime := CertificateRenewalTime([]secretguardianv1alpha1.KeySpec{*key}, K8SSecretData(values), now)
+		if complete && (renewalTime.IsZero() || now.Before(renewalTime)) {
+			if err := CompleteKeyValues(key, values, now); err != nil {
+				return nil, err
+			}
+			return values, nil
+		}
+	}
+	issuer, err := issuerFor()
+	if err != nil {
+		return nil, err
+	}
+	values, err := GenerateValue(spec, key, issuer)
 	if err != nil {
-		_, err := r.CreateUpdateK8SSecret(ctx, nameSpaceName, secretName, secretData, true) // create the secret in the k8s cluster
+		return nil, err
+	}
+	if name := key.JWKSName(); name != "" { // the new public key joins the previous ones
+		values[name], err = RotateJWKS(key.KeyPair.JWKS, previous[name], values[key.Name], now)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-	} else {
-		annotationTime, err := time.Parse(time.RFC3339, secretObj.Annotations["K8s-Secret-Rotation-Controller"]) // get the annotation time from the secret object
+	}
+	return values, nil
+}
+
+// function to list the keys of the secret derived from the value of a key:
+// its hashes, and the public key, JWK and JWKS of a key pair or the public key of an SSH key pair
+// a missing derived key is computed from the value, the value is not generated again
+// return the derived keys of the secret
+func DerivedKeys(key *secretguardianv1alpha1.KeySpec) map[string]bool {
+	derived := HashNames(key)
+	if (key.Generator == secretguardianv1alpha1.GeneratorKeyPair && key.KeyPair != nil) || (key.Generator == secretguardianv1alpha1.GeneratorSSHKeyPair && key.SSHKeyPair != nil) {
+		for _, name := range key.SecretKeys()[1:] { // every key but the private key
+			derived[name] = true
+		}
+	}
+	return derived
+}
+
+// function to list the keys of the hashes of a key
+// return the hash keys of the secret
+func HashNames(key *secretguardianv1alpha1.KeySpec) map[string]bool {
+	names := make(map[string]bool, len(key.Hashes))
+	for i := range key.Hashes {
+		names[key.HashName(&key.Hashes[i])] = true
+	}
+	return names
+}
+
+// function to compute the missing public keys, JWK and JWKS of a key pair, or public key of an SSH key pair,
+// from the private key in the values, e.g. after a jwkName was added to the spec
+// a missing JWKS is started with the current public key only
+// return an error if the private key can not be parsed
+func CompleteKeyValues(key *secretguardianv1alpha1.KeySpec, values map[string]string, now time.Time) error {
+	missing := func(name string) bool {
+		_, ok := values[name]
+		return name != "" && !ok
+	}
+	names := key.SecretKeys()
+	switch {
+	case key.Generator == secretguardianv1alpha1.GeneratorKeyPair && key.KeyPair != nil:
+		if !missing(names[1]) && !missing(key.KeyPair.JWKName) && !missing(key.JWKSName()) {
+			return nil
+		}
+		privateKey, err := generator.ParsePrivateKeyPEM(values[key.Name])
 		if err != nil {
-			return false, err
+			return err
+		}
+		if missing(names[1]) {
+			keyPair, err := generator.EncodeKeyPair(privateKey)
+			if err != nil {
+				return err
+			}
+			values[names[1]] = keyPair.PublicKeyPEM
+		}
+		if missing(key.KeyPair.JWKName) {
+			jwk, err := generator.PublicJWK(privateKey.Public())
+			if err != nil {
+				return err
+			}
+			jwkJSON, err := json.Marshal(jwk)
+			if err != nil {
+				return err
+			}
+			values[key.KeyPair.JWKName] = string(jwkJSON)
+		}
+		if name := key.JWKSName(); missing(name) {
+			jwks, err := RotateJWKS(key.KeyPair.JWKS, "", values[key.Name], now)
+			if err != nil {
+				return err
+			}
+			values[name] = jwks
+		}
+	case key.Generator == secretguardianv1alpha1.GeneratorSSHKeyPair && key.SSHKeyPair != nil:
+		if missing(names[1]) {
+			authorizedKey, err := generator.SSHAuthorizedKey(values[key.Name], key.SSHKeyPair.Comment)
+			if err != nil {
+				return err
+			}
+			values[names[1]] = authorizedKey
+		}
+	}
+	return nil
+}
+
+// function to generate the values of a key with its generator
+// the length and the password policy of the key default to the ones of the spec
+// issuer signs a certificate, it is nil for every other generator and for self-signed certificates
+// return the generated values by key of the secret, a single value for every generator but the key pairs and certificates
+func GenerateValue(spec *secretguardianv1alpha1.AWSSecretGuardianSpec, key *secretguardianv1alpha1.KeySpec, issuer *generator.Issuer) (map[string]string, error) {
+	length := spec.Length
+	if key.Length != nil {
+		length = *key.Length
+	}
+	var value string
+	var err error
+	switch key.Generator {
+	case secretguardianv1alpha1.GeneratorUUID:
+		value, err = generator.UUID()
+	case secretguardianv1alpha1.GeneratorHex:
+		value, err = generator.Hex(length)
+	case secretguardianv1alpha1.GeneratorBase64:
+		value, err = generator.Base64(length)
+	case secretguardianv1alpha1.GeneratorBase64URL:
+		value, err = generator.Base64URL(length)
+	case secretguardianv1alpha1.GeneratorPassphrase:
+		separator := generator.DefaultSeparator
+		if key.Separator != nil {
+			separator = *key.Separator
+		}
+		value, err = generator.Passphrase(length, separator)
+	case secretguardianv1alpha1.GeneratorPIN:
+		value, err = generator.PIN(length)
+	case secretguardianv1alpha1.GeneratorKeyPair:
+		return KeyPairValues(key)
+	case secretguardianv1alpha1.GeneratorSSHKeyPair:
+		return SSHKeyPairValues(key)
+	case secretguardianv1alpha1.GeneratorCertificate:
+		return CertificateValues(key, issuer)
+	case secretguardianv1alpha1.GeneratorPassword, "":
+		policy := spec.PasswordPolicy
+		if key.PasswordPolicy != nil {
+			policy = key.PasswordPolicy
 		}
-		if !time.Now().UTC().After(annotationTime.Add(time.Second * time.Duration(ttl))) { // check if the TTL of the secret has reached
-			return false, nil
+		if policy == nil {
+			value, err = generator.Password(length, generator.DefaultCharset)
+		} else {
+			value, err = generator.PolicyPassword(length, GeneratorPolicy(policy))
 		}
-		_, err = r.CreateUpdateK8SSecret(ctx, nameSpaceName, secretName, secretData, false) // update the secret in the k8s cluster
+	default:
+		return nil, fmt.Errorf("unknown generator %q", key.Generator)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{key.Name: value}, nil
+}
+
+// function to generate a key pair for a key
+// the PKCS#8 PEM private key is written to the key, the PKIX PEM public key and the optional JWK to their keys
+// the JWKS is added by KeyValues, it depends on the previous value
+// return the values by key of the secret
+func KeyPairValues(key *secretguardianv1alpha1.KeySpec) (map[string]string, error) {
+	if key.KeyPair == nil {
+		return nil, fmt.Errorf("%w: keyPair is required with the KeyPair generator", ErrInvalidKeys)
+	}
+	keyPair, err := generator.GenerateKeyPair(string(key.KeyPair.Algorithm), key.KeyPair.Bits, key.KeyPair.Curve)
+	if err != nil {
+		return nil, err
+	}
+	names := key.SecretKeys() // private key and public key names first
+	values := map[string]string{names[0]: keyPair.PrivateKeyPEM, names[1]: keyPair.PublicKeyPEM}
+	if key.KeyPair.JWKName != "" {
+		jwk, err := generator.PublicJWK(keyPair.PrivateKey.Public())
 		if err != nil {
+			return nil, err
+		}
+		jwkJSON, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+		values[key.KeyPair.JWKName] = string(jwkJSON)
+	}
+	return values, nil
+}
+
+// function to generate an SSH key pair for a key
+// the OpenSSH private key is written to the key and the authorized_keys line to its public key name
+// return the values by key of the secret
+func SSHKeyPairValues(key *secretguardianv1alpha1.KeySpec) (map[string]string, error) {
+	if key.SSHKeyPair == nil {
+		return nil, fmt.Errorf("%w: sshKeyPair is required with the SSHKeyPair generator", ErrInvalidKeys)
+	}
+	keyType := key.SSHKeyPair.Type
+	if keyType == "" {
+		keyType = secretguardianv1alpha1.KeyAlgorithmEd25519
+	}
+	keyPair, err := generator.GenerateSSHKeyPair(string(keyType), key.SSHKeyPair.Bits, key.SSHKeyPair.Curve, key.SSHKeyPair.Comment)
+	if err != nil {
+		return nil, err
+	}
+	names := key.SecretKeys() // private key and public key names
+	return map[string]string{names[0]: keyPair.PrivateKey, names[1]: keyPair.AuthorizedKey}, nil
+}
+
+// function to convert the password policy of the spec to the policy of the generator
+// every character class is included when the policy does not list any
+// return the generator policy
+func GeneratorPolicy(policy *secretguardianv1alpha1.PasswordPolicy) generator.Policy {
+	include := map[secretguardianv1alpha1.CharacterClass]bool{}
+	for _, class := range policy.Include {
+		include[class] = true
+	}
+	all := len(include) == 0
+	return generator.Policy{
+		Upper:                all || include[secretguardianv1alpha1.CharacterClassUpper],
+		Lower:                all || include[secretguardianv1alpha1.CharacterClassLower],
+		Digits:               all || include[secretguardianv1alpha1.CharacterClassDigit],
+		Symbols:              all || include[secretguardianv1alpha1.CharacterClassSymbol],
+		SymbolSet:            policy.Symbols,
+		Exclude:              policy.Exclude,
+		NoAmbiguous:          policy.NoAmbiguous,
+		MinUpper:             policy.MinUpper,
+		MinLower:             policy.MinLower,
+		MinDigits:            policy.MinDigits,
+		MinSymbols:           policy.MinSymbols,
+		NoRepeatedCharacters: policy.NoRepeatedCharacters,
+		StartWithLetter:      policy.StartWithLetter,
+	}
+}
+
+// function to create or update the target secret in the k8s cluster
+// if the secret already exists, it will update the secret with the new value
+// if the secret does not exist, it will create a new secret with the new value
+// return true if the secret is created or updated successfully
+func (r *AWSSecretGuardianReconciler) K8SSecretHandler(ctx context.Context, target *K8STarget, secretData map[string][]byte, versionID string, rotationTime time.Time) (bool, error) {
+	secretType := target.Type
+	secretObj, err := r.GetSecretK8S(ctx, target.Namespace, target.Name) // get the secret object from the k8s cluster
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil && secretObj.Type != secretType && (secretObj.Type != "" || secretType != corev1.SecretTypeOpaque) { // the type of a secret is immutable
+		if err := r.Delete(ctx, secretObj); err != nil && !apierrors.IsNotFound(err) {
 			return false, err
 		}
 	}
-	logger.Info(fmt.Sprintf("Secret %s/%s created or updated", nameSpaceName, secretName))
+	_, err = r.CreateUpdateK8SSecret(ctx, target, secretData, versionID, rotationTime) // create or update the secret in the k8s cluster
+	if err != nil {
+		return false, err
+	}
+	logger.Info(fmt.Sprintf("Secret %s/%s created or updated", target.Namespace, target.Name))
 	return true, nil
 }
 
@@ -265,29 +895,101 @@ func (r *AWSSecretGuardianReconciler) GetSecretK8S(ctx context.Context, nameSpac
 	return secretObj, nil // return the secret object
 }
 
-// function to create or update the secret in the k8s cluster
-// if the secret already exists, it will update the secret with a new password
-// if the secret does not exist, it will create a new secret with a new password
+// function to create or update the secret in the k8s cluster with a server-side apply
+// only the keys, labels and annotations applied by the controller are changed, the ones added by other tools are kept
+// and the ones the controller applied before but no longer applies are removed
+// the secret is annotated with the rotation time and the AWS version id of its value
+// the labels and annotations of the target are added, the controller annotations take precedence
+// the owner reference of the target makes the guardian the controller of the secret
 // return true if the secret is created or updated successfully
-func (r *AWSSecretGuardianReconciler) CreateUpdateK8SSecret(ctx context.Context, nameSpaceName string, secretName string, secretData map[string][]byte, create bool) (bool, error) {
-	utcTime := time.Now().UTC()
-	controllerAnnotation := map[string]string{"K8s-Secret-Rotation-Controller": utcTime.Format(time.RFC3339)} // create a new annotation for the secret object
-	secretObj := &corev1.Secret{                                                                              // create a new secret object
+func (r *AWSSecretGuardianReconciler) CreateUpdateK8SSecret(ctx context.Context, target *K8STarget, secretData map[string][]byte, versionID string, rotationTime time.Time) (bool, error) {
+	controllerAnnotation := map[string]string{} // create a new annotation for the secret object
+	for name, value := range target.Annotations {
+		controllerAnnotation[name] = value
+	}
+	controllerAnnotation[RotationAnnotation] = rotationTime.UTC().Format(time.RFC3339)
+	controllerAnnotation[VersionIDAnnotation] = versionID
+	if target.OwnerName != "" {
+		controllerAnnotation[OwnerAnnotation] = target.OwnerName
+	}
+	secretObj := &corev1.Secret{ // create a new secret object
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}, // required by an apply
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        secretName,
-			Namespace:   nameSpaceName,
+			Name:        target.Name,
+			Namespace:   target.Namespace,
+			Labels:      target.Labels,
 			Annotations: controllerAnnotation,
 		},
+		Type: target.Type,
 		Data: secretData,
 	}
-	var err error
-	if create { // check if the secret object needs to be created or updated
-		err = r.Create(ctx, secretObj)
-	} else {
-		err = r.Update(ctx, secretObj)
+	if target.Owner != nil { // an orphaned secret drops the owner reference of its previous apply
+		secretObj.OwnerReferences = []metav1.OwnerReference{*target.Owner}
 	}
-	if err != nil {
+	if err := r.ApplyK8SSecret(ctx, secretObj); err != nil {
 		return false, err
 	}
 	return true, nil
 }
+
+// ErrK8SSecretConflict is returned when another field manager owns a field of the k8s secret the guardian applies
+// and the field is not generated by the controller, e.g. a label of the target also set by another tool
+var ErrK8SSecretConflict = errors.New("k8s secret conflict")
+
+// function to apply the secret with the field manager of the controller
+// an apply conflicts when another field manager changed a field applied by the controller, e.g. kubectl edit,
+// the controller is the source of the keys and of its annotations, so the apply is retried forcing the ownership of those fields,
+// the labels and annotations of the target may be owned by another tool on purpose and are not taken over
+// return an error if the secret can not be applied, wrapping ErrK8SSecretConflict if another field manager owns a target field
+func (r *AWSSecretGuardianReconciler) ApplyK8SSecret(ctx context.Context, secretObj *corev1.Secret) error {
+	err := r.Patch(ctx, secretObj.DeepCopy(), client.Apply, client.FieldOwner(FieldManager))
+	if !apierrors.IsConflict(err) {
+		return err
+	}
+	var foreign []string // the conflicting fields the controller does not own
+	for _, field := range ConflictFields(err) {
+		if !ControllerOwnedField(secretObj, field) {
+			foreign = append(foreign, field)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%w: Secret %s/%s, fields %s are owned by another field manager: %s", ErrK8SSecretConflict, secretObj.Namespace, secretObj.Name, strings.Join(foreign, ", "), err)
+	}
+	logger.Info(fmt.Sprintf("Secret %s/%s was changed by another field manager, forcing the apply: %s", secretObj.Namespace, secretObj.Name, err))
+	return r.Patch(ctx, secretObj.DeepCopy(), client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership)
+}
+
+// function to get the fields of an apply conflict, e.g. .data.password or .metadata.labels.app
+// return the fields, empty if the error does not list them
+func ConflictFields(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	var fields []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			fields = append(fields, cause.Field)
+		}
+	}
+	return fields
+}
+
+// function to check if a field of the secret is generated by the controller:
+// its keys, its type, its owner reference and the annotations written by the controller
+// return true if the ownership of the field can be forced
+func ControllerOwnedField(secretObj *corev1.Secret, field string) bool {
+	if field == ".type" || strings.HasPrefix(field, ".metadata.ownerReferences") {
+		return true
+	}
+	if key, ok := strings.CutPrefix(field, ".data."); ok {
+		_, generated := secretObj.Data[key]
+		return generated
+	}
+	for _, annotation := range []string{RotationAnnotation, VersionIDAnnotation, OwnerAnnotation} {
+		if field == ".metadata.annotations."+annotation {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+)
+
+// ErrIssuerExpiring is returned when the CA of a certificate expires within the renewal window of the certificate,
+// a certificate signed by it would be due for renewal as soon as it is issued
+var ErrIssuerExpiring = errors.New("issuer expires within the renewal window of the certificate")
+
+// keyUsages maps the usages of the spec to the key usages of a certificate
+var keyUsages = map[secretguardianv1alpha1.KeyUsage]x509.KeyUsage{
+	secretguardianv1alpha1.KeyUsageDigitalSignature: x509.KeyUsageDigitalSignature,
+	secretguardianv1alpha1.KeyUsageKeyEncipherment:  x509.KeyUsageKeyEncipherment,
+	secretguardianv1alpha1.KeyUsageKeyAgreement:     x509.KeyUsageKeyAgreement,
+	secretguardianv1alpha1.KeyUsageCertSign:         x509.KeyUsageCertSign,
+	secretguardianv1alpha1.KeyUsageCRLSign:          x509.KeyUsageCRLSign,
+}
+
+// extKeyUsages maps the usages of the spec to the extended key usages of a certificate
+var extKeyUsages = map[secretguardianv1alpha1.KeyUsage]x509.ExtKeyUsage{
+	secretguardianv1alpha1.KeyUsageServerAuth:      x509.ExtKeyUsageServerAuth,
+	secretguardianv1alpha1.KeyUsageClientAuth:      x509.ExtKeyUsageClientAuth,
+	secretguardianv1alpha1.KeyUsageCodeSigning:     x509.ExtKeyUsageCodeSigning,
+	secretguardianv1alpha1.KeyUsageEmailProtection: x509.ExtKeyUsageEmailProtection,
+}
+
+// function to get the CA signing the certificate of a key
+// the CA is read from the tls.crt and tls.key of the issuerRef Secret in the namespace
+// return nil for keys that are not certificates and for self-signed certificates
+func (r *AWSSecretGuardianReconciler) CertificateIssuer(ctx context.Context, nameSpaceName string, key *secretguardianv1alpha1.KeySpec) (*generator.Issuer, error) {
+	if key.Generator != secretguardianv1alpha1.GeneratorCertificate || key.Certificate == nil || key.Certificate.IssuerRef == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: key.Certificate.IssuerRef.Name, Namespace: nameSpaceName}, secret); err != nil {
+		return nil, fmt.Errorf("issuer %s: %w", key.Certificate.IssuerRef.Name, err)
+	}
+	issuer, err := generator.ParseIssuer(string(secret.Data[corev1.TLSCertKey]), string(secret.Data[corev1.TLSPrivateKeyKey]))
+	if err != nil {
+		return nil, fmt.Errorf("issuer %s: %w", key.Certificate.IssuerRef.Name, err)
+	}
+	return issuer, nil
+}
+
+// function to issue a certificate for a key
+// the PEM certificate is written to the key, the private key to its private key name and the CA certificate to ca.crt
+// the validity of the certificate is capped by the CA, so a CA expiring within renewBefore is refused
+// return the values by key of the secret, or an error wrapping ErrIssuerExpiring
+func CertificateValues(key *secretguardianv1alpha1.KeySpec, issuer *generator.Issuer) (map[string]string, error) {
+	spec := key.Certificate
+	if spec == nil {
+		return nil, fmt.Errorf("%w: certificate is required with the Certificate generator", ErrInvalidKeys)
+	}
+	if spec.IssuerRef != nil && issuer == nil {
+		return nil, fmt.Errorf("issuer %s not loaded", spec.IssuerRef.Name)
+	}
+	if renewBefore := CertificateRenewBefore(spec); issuer != nil && time.Until(issuer.Certificate.NotAfter) <= renewBefore {
+		return nil, fmt.Errorf("%w: issuer %s expires at %s, less than renewBefore %s from now, renew the issuer",
+			ErrIssuerExpiring, spec.IssuerRef.Name, issuer.Certificate.NotAfter.UTC().Format(time.RFC3339), renewBefore)
+	}
+	request, err := CertificateRequestFor(spec)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := spec.Algorithm
+	if algorithm == "" {
+		algorithm = secretguardianv1alpha1.KeyAlgorithmRSA
+	}
+	certificate, err := generator.GenerateCertificate(request, string(algorithm), spec.Bits, spec.Curve, issuer)
+	if err != nil {
+		return nil, err
+	}
+	names := key.SecretKeys() // certificate, private key and CA names
+	values := map[string]string{names[0]: certificate.CertificatePEM, names[1]: certificate.PrivateKeyPEM}
+	if len(names) > 2 {
+		values[names[2]] = certificate.CAPEM
+	}
+	return values, nil
+}
+
+// function to convert the certificate spec of a key to the request of the generator
+// return the request, or ErrInvalidKeys if an IP address or the renewal window is invalid
+func CertificateRequestFor(spec *secretguardianv1alpha1.CertificateSpec) (generator.CertificateRequest, error) {
+	request := generator.CertificateRequest{
+		CommonName: spec.CommonName,
+		DNSNames:   spec.DNSNames,
+		Duration:   CertificateDuration(spec),
+		IsCA:       spec.IsCA,
+	}
+	if renewBefore := CertificateRenewBefore(spec); renewBefore >= request.Duration {
+		return request, fmt.Errorf("%w: renewBefore %s is not shorter than duration %s", ErrInvalidKeys, renewBefore, request.Duration)
+	}
+	for _, address := range spec.IPAddresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return request, fmt.Errorf("%w: invalid IP address %q", ErrInvalidKeys, address)
+		}
+		request.IPAddresses = append(request.IPAddresses, ip)
+	}
+	usages := spec.Usages
+	if len(usages) == 0 {
+		usages = []secretguardianv1alpha1.KeyUsage{secretguardianv1alpha1.KeyUsageDigitalSignature, secretguardianv1alpha1.KeyUsageKeyEncipherment, secretguardianv1alpha1.KeyUsageServerAuth}
+		if spec.IsCA {
+			usages = append(usages, secretguardianv1alpha1.KeyUsageCertSign)
+		}
+	}
+	for _, usage := range usages {
+		if keyUsage, ok := keyUsages[usage]; ok {
+			request.KeyUsage |= keyUsage
+		} else if extKeyUsage, ok := extKeyUsages[usage]; ok {
+			request.ExtKeyUsage = append(request.ExtKeyUsage, extKeyUsage)
+		} else {
+			return request, fmt.Errorf("%w: unknown usage %q", ErrInvalidKeys, usage)
+		}
+	}
+	return request, nil
+}
+
+// function to get the validity of a certificate
+// return the duration of the spec, generator.DefaultCertificateDuration if not set
+func CertificateDuration(spec *secretguardianv1alpha1.CertificateSpec) time.Duration {
+	if spec.Duration == nil || spec.Duration.Duration <= 0 {
+		return generator.DefaultCertificateDuration
+	}
+	return spec.Duration.Duration
+}
+
+// function to get how long before its expiry a certificate is renewed
+// return the renewBefore of the spec, a third of the duration if not set
+func CertificateRenewBefore(spec *secretguardianv1alpha1.CertificateSpec) time.Duration {
+	if spec.RenewBefore == nil {
+		return CertificateDuration(spec) / 3
+	}
+	return spec.RenewBefore.Duration
+}
+
+// function to compute when the first certificate of the secret is due for renewal
+// a certificate that is missing or can not be parsed is due now
+// return the renewal time, zero if no key is a certificate
+func CertificateRenewalTime(keys []secretguardianv1alpha1.KeySpec, data map[string][]byte, now time.Time) time.Time {
+	var renewalTime time.Time
+	for _, key := range keys {
+		if key.Generator != secretguardianv1alpha1.GeneratorCertificate || key.Certificate == nil {
+			continue
+		}
+		keyRenewalTime := now
+		if certificate, err := generator.ParseCertificatePEM(string(data[key.Name])); err == nil {
+			keyRenewalTime = certificate.NotAfter.Add(-CertificateRenewBefore(key.Certificate))
+		}
+		if renewalTime.IsZero() || keyRenewalTime.Before(renewalTime) {
+			renewalTime = keyRenewalTime
+		}
+	}
+	return renewalTime
+}
+
+// function to get the type of the k8s secret written for the keys
+// return kubernetes.io/tls if a certificate is written to tls.crt with its private key in tls.key, Opaque otherwise
+func K8SSecretType(keys []secretguardianv1alpha1.KeySpec) corev1.SecretType {
+	for _, key := range keys {
+		if key.Generator != secretguardianv1alpha1.GeneratorCertificate || key.Certificate == nil {
+			continue
+		}
+		if names := key.SecretKeys(); names[0] == corev1.TLSCertKey && names[1] == corev1.TLSPrivateKeyKey {
+			return corev1.SecretTypeTLS
+		}
+	}
+	return corev1.SecretTypeOpaque
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/awsstore"
+)
+
+// Default keys of the credentials in the credentials Secret
+const (
+	DefaultAccessKeyIDKey     = "access-key"
+	DefaultSecretAccessKeyKey = "secret-access-key"
+)
+
+// ErrCredentialsRefForbidden is returned when a guardian references credentials outside of its namespace
+var ErrCredentialsRefForbidden = errors.New("credentialsRef must point to a Secret in the namespace of the AWSSecretGuardian")
+
+// ErrAuthTypeForbidden is returned when a guardian uses the AWS identity of the controller without the controller allowing it
+var ErrAuthTypeForbidden = errors.New("the Default, WebIdentity and PodIdentity auth types use the AWS identity of the controller and are only allowed when the controller runs with --allow-controller-identity")
+
+// ErrAssumeRoleFailed is returned when the role of spec.auth.assumeRole can not be assumed
+var ErrAssumeRoleFailed = errors.New("failed to assume role")
+
+// AWSCredentials holds static AWS credentials loaded from a Secret
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	ResourceVersion string // resource version of the Secret the credentials were read from
+}
+
+// function to resolve the credentials Secret of the AWSSecretGuardian
+// the credentialsRef of the guardian is used if set, the controller default credentials Secret otherwise
+// the controller can read Secrets in every namespace, so a guardian is only allowed to reference
+// Secrets in its own namespace, otherwise anyone allowed to create a guardian could use any credentials in the cluster
+// return the reference with the namespace and the key names filled in
+func (r *AWSSecretGuardianReconciler) CredentialsRefFor(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (*secretguardianv1alpha1.CredentialsReference, error) {
+	var credentialsRef *secretguardianv1alpha1.CredentialsReference
+	if awsSecretGuardian.Spec.CredentialsRef == nil {
+		if r.DefaultCredentialsSecret.Name == "" {
+			return nil, errors.New("no credentialsRef set and no default credentials Secret configured")
+		}
+		credentialsRef = &secretguardianv1alpha1.CredentialsReference{ // the default is set by the cluster admin and may live in any namespace
+			Name:      r.DefaultCredentialsSecret.Name,
+			Namespace: r.DefaultCredentialsSecret.Namespace,
+		}
+	} else {
+		credentialsRef = awsSecretGuardian.Spec.CredentialsRef.DeepCopy()
+		if credentialsRef.Namespace == "" {
+			credentialsRef.Namespace = awsSecretGuardian.Namespace
+		}
+		if credentialsRef.Namespace != awsSecretGuardian.Namespace {
+			return nil, fmt.Errorf("%w: %s/%s", ErrCredentialsRefForbidden, credentialsRef.Namespace, credentialsRef.Name)
+		}
+	}
+	if credentialsRef.AccessKeyIDKey == "" {
+		credentialsRef.AccessKeyIDKey = DefaultAccessKeyIDKey
+	}
+	if credentialsRef.SecretAccessKeyKey == "" {
+		credentialsRef.SecretAccessKeyKey = DefaultSecretAccessKeyKey
+	}
+	return credentialsRef, nil
+}
+
+// function to find the AWSSecretGuardians that use the Secret as static credentials or as Vault token or secret id
+// used to reconcile the guardians, and refresh their cached AWS clients and Vault tokens, when their credentials Secret changes
+// return a reconcile request for each guardian
+func (r *AWSSecretGuardianReconciler) GuardiansForCredentialsSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	var listOptions []client.ListOption
+	if secret.GetNamespace() != r.DefaultCredentialsSecret.Namespace || secret.GetName() != r.DefaultCredentialsSecret.Name {
+		listOptions = append(listOptions, client.InNamespace(secret.GetNamespace())) // only the default Secret is used across namespaces
+	}
+	awsSecretGuardiansList := &secretguardianv1alpha1.AWSSecretGuardianList{}
+	if err := r.List(ctx, awsSecretGuardiansList, listOptions...); err != nil {
+		logger.Info(fmt.Sprintf("Error listing the AWSSecretGuardians using Secret %s/%s: %s", secret.GetNamespace(), secret.GetName(), err))
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range awsSecretGuardiansList.Items {
+		awsSecretGuardian := &awsSecretGuardiansList.Items[i]
+		if awsSecretGuardian.Spec.Provider == secretguardianv1alpha1.ProviderVault {
+			if ref := VaultSecretRefFor(awsSecretGuardian); ref != nil && awsSecretGuardian.Namespace == secret.GetNamespace() && ref.Name == secret.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(awsSecretGuardian)})
+			}
+			continue
+		}
+		if auth := awsSecretGuardian.Spec.Auth; auth != nil && auth.Type != "" && auth.Type != secretguardianv1alpha1.AuthTypeStatic {
+			continue
+		}
+		credentialsRef, err := r.CredentialsRefFor(awsSecretGuardian)
+		if err != nil || credentialsRef.Namespace != secret.GetNamespace() || credentialsRef.Name != secret.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(awsSecretGuardian)})
+	}
+	return requests
+}
+
+// function to get the access key, secret key and session token from the secret
+// return an error if the access key or the secret key is empty
+func (r *AWSSecretGuardianReconciler) GetCreds(ctx context.Context, credentialsRef *secretguardianv1alpha1.CredentialsReference) (*AWSCredentials, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Name: credentialsRef.Name, Namespace: credentialsRef.Namespace}, secret)
+	if err != nil {
+		return nil, err
+	}
+	creds := &AWSCredentials{
+		AccessKeyID:     string(secret.Data[credentialsRef.AccessKeyIDKey]),
+		SecretAccessKey: string(secret.Data[credentialsRef.SecretAccessKeyKey]),
+		ResourceVersion: secret.ResourceVersion,
+	}
+	if credentialsRef.SessionTokenKey != "" {
+		creds.SessionToken = string(secret.Data[credentialsRef.SessionTokenKey])
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%s or %s is empty in Secret %s/%s", credentialsRef.AccessKeyIDKey, credentialsRef.SecretAccessKeyKey, credentialsRef.Namespace, credentialsRef.Name)
+	}
+	return creds, nil
+}
+
+// function to get the AWS clients of the AWSSecretGuardian
+// clients are cached by region and identity, so sessions and temporary credentials are reused between reconciles,
+// the clients of an assumed role are cached for the guardian alone
+// the base credentials of the auth type are used to assume spec.auth.assumeRole when it is set
+// the role is assumed right away so a failure is reported with ErrAssumeRoleFailed
+// return the AWS clients of the guardian
+func (r *AWSSecretGuardianReconciler) AWSClientsFor(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (*awsstore.Clients, error) {
+	region := awsSecretGuardian.Spec.Region
+	identity, newCredentials, err := r.BaseCredentialsFor(ctx, awsSecretGuardian)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := r.awsClients.GetOrCreate(region, identity, newCredentials)
+	if err != nil {
+		return nil, err
+	}
+	if awsSecretGuardian.Spec.Auth == nil || awsSecretGuardian.Spec.Auth.AssumeRole == nil {
+		return clients, nil
+	}
+
+	assumeRole := awsSecretGuardian.Spec.Auth.AssumeRole
+	spec, err := json.Marshal(assumeRole)
+	if err != nil {
+		return nil, err
+	}
+	// the session name defaults to the guardian, so the assumed clients are private to the guardian,
+	// a changed role replaces them and they are dropped with the guardian
+	assumedIdentity := awsstore.CredentialsID{
+		Source:  identity.Source + "|assume-role", // the base and the assumed clients do not evict each other
+		Version: fmt.Sprintf("%s|%x", identity.Version, sha256.Sum256(spec)),
+		Owner:   client.ObjectKeyFromObject(awsSecretGuardian).String(),
+	}
+	baseSTS := clients.STS // the role is assumed with the base credentials
+	clients, err = r.awsClients.GetOrCreate(region, assumedIdentity, func() (*credentials.Credentials, error) {
+		return AssumeRoleCredentials(baseSTS, assumeRole, awsSecretGuardian), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := clients.Credentials.GetWithContext(ctx); err != nil { // assume the role now, cached until the expiry window
+		return nil, fmt.Errorf("%w %s: %s", ErrAssumeRoleFailed, assumeRole.RoleARN, err)
+	}
+	return clients, nil
+}
+
+// function to resolve the base AWS credentials of the AWSSecretGuardian according to its auth type
+// Static reads the access keys from the credentials Secret, every other type uses the identity of the controller pod,
+// which is shared by every namespace, so those types are only allowed with AllowControllerIdentity
+// the credentials are only built by the returned function, which is called when the clients are not cached yet
+// return the identity of the credentials and the function building them, or an error wrapping ErrAuthTypeForbidden
+func (r *AWSSecretGuardianReconciler) BaseCredentialsFor(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (awsstore.CredentialsID, func() (*credentials.Credentials, error), error) {
+	auth := awsSecretGuardian.Spec.Auth
+	if auth == nil {
+		auth = &secretguardianv1alpha1.AuthSpec{}
+	}
+	if auth.Type != "" && auth.Type != secretguardianv1alpha1.AuthTypeStatic && !r.AllowControllerIdentity {
+		return awsstore.CredentialsID{}, nil, fmt.Errorf("%w: auth type %s", ErrAuthTypeForbidden, auth.Type)
+	}
+	switch auth.Type {
+	case "", secretguardianv1alpha1.AuthTypeStatic:
+		credentialsRef, err := r.CredentialsRefFor(awsSecretGuardian) // get the reference to the credentials Secret of the guardian
+		if err != nil {
+			return awsstore.CredentialsID{}, nil, err
+		}
+		source := fmt.Sprintf("static:%s/%s", credentialsRef.Namespace, credentialsRef.Name)
+		creds, err := r.GetCreds(ctx, credentialsRef) // get the access key and secret key from the credentials Secret
+		if err != nil {
+			if apierrors.IsNotFound(err) { // the Secret was deleted, its clients must not be used anymore
+				r.awsClients.Invalidate(source)
+			}
+			return awsstore.CredentialsID{}, nil, err
+		}
+		identity := awsstore.CredentialsID{Source: source, Version: creds.ResourceVersion} // a new resource version of the Secret replaces the cached clients
+		return identity, func() (*credentials.Credentials, error) {
+			return credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken), nil
+		}, nil
+	case secretguardianv1alpha1.AuthTypeDefault:
+		return awsstore.CredentialsID{Source: "default"}, func() (*credentials.Credentials, error) {
+			sess, err := session.NewSession() // the session resolves the default chain: environment, shared config, web identity, container and EC2 role
+			if err != nil {
+				return nil, err
+			}
+			return sess.Config.Credentials, nil
+		}, nil
+	case secretguardianv1alpha1.AuthTypeWebIdentity:
+		return WebIdentityCredentials(auth.WebIdentity, awsSecretGuardian)
+	case secretguardianv1alpha1.AuthTypePodIdentity:
+		if os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") == "" {
+			return awsstore.CredentialsID{}, nil, errors.New("AWS_CONTAINER_CREDENTIALS_FULL_URI is not set, is the EKS Pod Identity agent associated with the controller service account?")
+		}
+		return awsstore.CredentialsID{Source: "pod-identity"}, func() (*credentials.Credentials, error) {
+			return credentials.NewCredentials(defaults.RemoteCredProvider(*defaults.Config(), defaults.Handlers())), nil
+		}, nil
+	}
+	return awsstore.CredentialsID{}, nil, fmt.Errorf("unknown auth type %q", auth.Type)
+}
+
+// function to build the credentials of spec.auth.assumeRole on top of the STS client of the base credentials
+// the SDK caches the temporary credentials and refreshes them before they expire
+// return the credentials provider of the assumed role
+func AssumeRoleCredentials(baseSTS stscreds.AssumeRoler, assumeRole *secretguardianv1alpha1.AssumeRoleSpec, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) *credentials.Credentials {
+	sessionName := assumeRole.SessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("%s-%s", awsSecretGuardian.Namespace, awsSecretGuardian.Name)
+	}
+	duration := stscreds.DefaultDuration
+	if assumeRole.DurationSeconds != nil {
+		duration = time.Duration(*assumeRole.DurationSeconds) * time.Second
+	}
+	return stscreds.NewCredentialsWithClient(baseSTS, assumeRole.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		p.Duration = duration
+		p.ExpiryWindow = duration / 10 // refresh before the credentials expire
+		if assumeRole.ExternalID != "" {
+			p.ExternalID = aws.String(assumeRole.ExternalID)
+		}
+		for _, tag := range assumeRole.Tags {
+			p.Tags = append(p.Tags, &sts.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+		}
+	})
+}
+
+// function to resolve web identity (IRSA) credentials
+// the token file is the AWS_WEB_IDENTITY_TOKEN_FILE of the controller, it is never taken from the spec,
+// the role defaults to the AWS_ROLE_ARN of the controller
+// return the identity of the credentials and the function building them
+func WebIdentityCredentials(webIdentity *secretguardianv1alpha1.WebIdentityAuth, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (awsstore.CredentialsID, func() (*credentials.Credentials, error), error) {
+	if webIdentity == nil {
+		webIdentity = &secretguardianv1alpha1.WebIdentityAuth{}
+	}
+	roleARN, tokenFile, sessionName := webIdentity.RoleARN, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), webIdentity.SessionName
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	if roleARN == "" || tokenFile == "" {
+		return awsstore.CredentialsID{}, nil, errors.New("web identity needs a role ARN and a token file, set spec.auth.webIdentity.roleArn or AWS_ROLE_ARN, and AWS_WEB_IDENTITY_TOKEN_FILE on the controller")
+	}
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("%s-%s", awsSecretGuardian.Namespace, awsSecretGuardian.Name)
+	}
+	identity := awsstore.CredentialsID{Source: fmt.Sprintf("web-identity:%s:%s", roleARN, sessionName)}
+	return identity, func() (*credentials.Credentials, error) {
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(awsSecretGuardian.Spec.Region)}) // AssumeRoleWithWebIdentity is not signed, the session credentials are not used
+		if err != nil {
+			return nil, err
+		}
+		return stscreds.NewWebIdentityCredentials(sess, roleARN, sessionName, tokenFile), nil
+	}, nil
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/awsstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+// function to create a credentials Secret for the tests
+// return the Secret
+func newCredentialsSecret(namespace string, name string, data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Data: map[string][]byte{}}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+func TestCredentialsRefFor(t *testing.T) {
+	defaultSecret := types.NamespacedName{Namespace: "awssecretguardian", Name: "aws-creds"}
+	cases := map[string]struct {
+		defaultSecret  types.NamespacedName
+		credentialsRef *secretguardianv1alpha1.CredentialsReference
+		want           *secretguardianv1alpha1.CredentialsReference // nil if an error is expected
+		wantForbidden  bool
+	}{
+		"controller default": {
+			defaultSecret: defaultSecret,
+			want:          &secretguardianv1alpha1.CredentialsReference{Name: "aws-creds", Namespace: "awssecretguardian", AccessKeyIDKey: DefaultAccessKeyIDKey, SecretAccessKeyKey: DefaultSecretAccessKeyKey},
+		},
+		"no default": {},
+		"own namespace by default": {
+			defaultSecret:  defaultSecret,
+			credentialsRef: &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"},
+			want:           &secretguardianv1alpha1.CredentialsReference{Name: "team-creds", Namespace: "default", AccessKeyIDKey: DefaultAccessKeyIDKey, SecretAccessKeyKey: DefaultSecretAccessKeyKey},
+		},
+		"custom keys": {
+			credentialsRef: &secretguardianv1alpha1.CredentialsReference{Name: "team-creds", Namespace: "default", AccessKeyIDKey: "id", SecretAccessKeyKey: "secret", SessionTokenKey: "token"},
+			want:           &secretguardianv1alpha1.CredentialsReference{Name: "team-creds", Namespace: "default", AccessKeyIDKey: "id", SecretAccessKeyKey: "secret", SessionTokenKey: "token"},
+		},
+		"default Secret referenced explicitly": {
+			defaultSecret:  defaultSecret,
+			credentialsRef: &secretguardianv1alpha1.CredentialsReference{Name: "aws-creds", Namespace: "awssecretguardian"},
+			wantForbidden:  true,
+		},
+		"other namespace": {
+			credentialsRef: &secretguardianv1alpha1.CredentialsReference{Name: "team-creds", Namespace: "payments"},
+			wantForbidden:  true,
+		},
+	}
+	for name, c := range cases {
+		r := &AWSSecretGuardianReconciler{DefaultCredentialsSecret: c.defaultSecret}
+		guardian := newTestGuardian()
+		guardian.Spec.CredentialsRef = c.credentialsRef
+		got, err := r.CredentialsRefFor(guardian)
+		if c.want == nil {
+			if err == nil || errors.Is(err, ErrCredentialsRefForbidden) != c.wantForbidden {
+				t.Errorf("%s: %+v, %v, want an error, forbidden %v", name, got, err, c.wantForbidden)
+			}
+			continue
+		}
+		if err != nil || *got != *c.want {
+			t.Errorf("%s: %+v, %v, want %+v", name, got, err, c.want)
+		}
+	}
+}
+
+func TestGetCreds(t *testing.T) {
+	ref := &secretguardianv1alpha1.CredentialsReference{Name: "team-creds", Namespace: "default", AccessKeyIDKey: "access-key", SecretAccessKeyKey: "secret-access-key"}
+	withToken := *ref
+	withToken.SessionTokenKey = "session-token"
+	cases := map[string]struct {
+		secret  *corev1.Secret
+		ref     *secretguardianv1alpha1.CredentialsReference
+		want    AWSCredentials
+		wantErr bool
+	}{
+		"static keys": {
+			secret: newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET", "session-token": "ignored"}),
+			ref:    ref,
+			want:   AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"},
+		},
+		"session token": {
+			secret: newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET", "session-token": "TOKEN"}),
+			ref:    &withToken,
+			want:   AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "TOKEN"},
+		},
+		"missing secret key": {
+			secret:  newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID"}),
+			ref:     ref,
+			wantErr: true,
+		},
+		"secret in another namespace": {
+			secret:  newCredentialsSecret("payments", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"}),
+			ref:     ref,
+			wantErr: true,
+		},
+	}
+	for name, c := range cases {
+		r := newTestReconciler(t, fake.New(), c.secret)
+		got, err := r.GetCreds(context.Background(), c.ref)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: credentials %+v, want an error", name, got)
+			}
+			continue
+		}
+		if err != nil || got.AccessKeyID != c.want.AccessKeyID || got.SecretAccessKey != c.want.SecretAccessKey || got.SessionToken != c.want.SessionToken {
+			t.Errorf("%s: %+v, %v, want %+v", name, got, err, c.want)
+		}
+		if got.ResourceVersion == "" {
+			t.Errorf("%s: the resource version of the Secret was not recorded", name)
+		}
+	}
+
+	r := newTestReconciler(t, fake.New())
+	if _, err := r.GetCreds(context.Background(), ref); !apierrors.IsNotFound(err) {
+		t.Errorf("deleted Secret: %v, want not found", err)
+	}
+}
+
+func TestBaseCredentialsFor(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/controller")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/eks.amazonaws.com/serviceaccount/token")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "http://169.254.170.23/v1/credentials")
+	credentialsSecret := newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"})
+	cases := map[string]struct {
+		auth                    *secretguardianv1alpha1.AuthSpec
+		allowControllerIdentity bool
+		wantSource              string // empty if an error is expected
+		wantForbidden           bool
+	}{
+		"static":                   {auth: nil, wantSource: "static:default/team-creds"},
+		"default forbidden":        {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeDefault}, wantForbidden: true},
+		"web identity forbidden":   {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeWebIdentity}, wantForbidden: true},
+		"pod identity forbidden":   {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypePodIdentity}, wantForbidden: true},
+		"default allowed":          {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeDefault}, allowControllerIdentity: true, wantSource: "default"},
+		"pod identity allowed":     {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypePodIdentity}, allowControllerIdentity: true, wantSource: "pod-identity"},
+		"web identity of the pod":  {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeWebIdentity}, allowControllerIdentity: true, wantSource: "web-identity:arn:aws:iam::123456789012:role/controller:default-guardian"},
+		"web identity with a role": {auth: &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeWebIdentity, WebIdentity: &secretguardianv1alpha1.WebIdentityAuth{RoleARN: "arn:aws:iam::123456789012:role/team-a", SessionName: "team-a"}}, allowControllerIdentity: true, wantSource: "web-identity:arn:aws:iam::123456789012:role/team-a:team-a"},
+	}
+	for name, c := range cases {
+		r := newTestReconciler(t, fake.New(), credentialsSecret)
+		r.AllowControllerIdentity = c.allowControllerIdentity
+		guardian := newTestGuardian()
+		guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"}
+		guardian.Spec.Auth = c.auth
+		identity, newCredentials, err := r.BaseCredentialsFor(context.Background(), guardian)
+		if c.wantSource == "" {
+			if err == nil || errors.Is(err, ErrAuthTypeForbidden) != c.wantForbidden {
+				t.Errorf("%s: %+v, %v, want an error, forbidden %v", name, identity, err, c.wantForbidden)
+			}
+			continue
+		}
+		if err != nil || identity.Source != c.wantSource || newCredentials == nil {
+			t.Errorf("%s: %+v, %v, want source %s", name, identity, err, c.wantSource)
+		}
+	}
+}
+
+func TestReconcileRefusesControllerIdentity(t *testing.T) {
+	guardian := newTestGuardian()
+	guardian.Spec.Auth = &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypeDefault}
+	r := newTestReconciler(t, nil, guardian)
+	r.NewSecretStore = nil // resolve the AWS credentials of spec.auth
+
+	result, got, secret := reconcileGuardian(t, r)
+	if secret != nil {
+		t.Error("a secret was written with the identity of the controller")
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionAWSReachable)
+	if condition == nil || condition.Reason != ReasonAuthTypeForbidden || result.RequeueAfter != 0 {
+		t.Errorf("AWSReachable condition %v, requeue %s, want AuthTypeForbidden without requeue", condition, result.RequeueAfter)
+	}
+}
+
+// mockSTS records the AssumeRole calls made with the base credentials
+type mockSTS struct {
+	stsiface.STSAPI
+	inputs []*sts.AssumeRoleInput
+	err    error
+}
+
+func (m *mockSTS) AssumeRoleWithContext(ctx aws.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	m.inputs = append(m.inputs, input)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASSUMED"),
+		SecretAccessKey: aws.String("ASSUMED-SECRET"),
+		SessionToken:    aws.String("ASSUMED-TOKEN"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func (m *mockSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	return m.AssumeRoleWithContext(context.Background(), input)
+}
+
+// function to create a reconciler whose AWS clients use the mock STS client
+// return the reconciler
+func newAWSTestReconciler(t *testing.T, stsClient *mockSTS, objects ...runtime.Object) *AWSSecretGuardianReconciler {
+	t.Helper()
+	r := newTestReconciler(t, nil, objects...)
+	r.NewSecretStore = nil
+	r.awsClients.NewClients = func(region string, creds *credentials.Credentials) (*awsstore.Clients, error) {
+		return &awsstore.Clients{Credentials: creds, STS: stsClient}, nil
+	}
+	return r
+}
+
+func TestAWSClientsForAssumeRole(t *testing.T) {
+	credentialsSecret := newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"})
+	duration := int64(900)
+	guardian := newTestGuardian()
+	guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"}
+	guardian.Spec.Auth = &secretguardianv1alpha1.AuthSpec{AssumeRole: &secretguardianv1alpha1.AssumeRoleSpec{
+		RoleARN:         "arn:aws:iam::210987654321:role/secret-guardian",
+		ExternalID:      "team-a",
+		DurationSeconds: &duration,
+		Tags:            []secretguardianv1alpha1.SessionTag{{Key: "team", Value: "a"}},
+	}}
+	stsClient := &mockSTS{}
+	r := newAWSTestReconciler(t, stsClient, credentialsSecret)
+
+	ctx := context.Background()
+	clients, err := r.AWSClientsFor(ctx, guardian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := clients.Credentials.Get()
+	if err != nil || value.AccessKeyID != "ASSUMED" || value.SessionToken != "ASSUMED-TOKEN" {
+		t.Errorf("credentials %+v, %v, want the credentials of the assumed role", value, err)
+	}
+	if len(stsClient.inputs) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(stsClient.inputs))
+	}
+	input := stsClient.inputs[0]
+	if aws.StringValue(input.RoleArn) != "arn:aws:iam::210987654321:role/secret-guardian" || aws.StringValue(input.ExternalId) != "team-a" ||
+		aws.StringValue(input.RoleSessionName) != "default-guardian" || aws.Int64Value(input.DurationSeconds) != 900 ||
+		len(input.Tags) != 1 || aws.StringValue(input.Tags[0].Key) != "team" {
+		t.Errorf("AssumeRole input %v", input)
+	}
+
+	if _, err := r.AWSClientsFor(ctx, guardian); err != nil || len(stsClient.inputs) != 1 {
+		t.Errorf("%v, %d AssumeRole calls, want the cached credentials of the role", err, len(stsClient.inputs))
+	}
+}
+
+func TestAWSClientsForAssumeRolePerGuardian(t *testing.T) {
+	credentialsSecret := newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"})
+	guardian := newTestGuardian()
+	guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"}
+	guardian.Spec.Auth = &secretguardianv1alpha1.AuthSpec{AssumeRole: &secretguardianv1alpha1.AssumeRoleSpec{RoleARN: "arn:aws:iam::210987654321:role/secret-guardian"}}
+	other := guardian.DeepCopy()
+	other.Name = "other"
+	stsClient := &mockSTS{}
+	r := newAWSTestReconciler(t, stsClient, credentialsSecret)
+
+	ctx := context.Background()
+	for _, g := range []*secretguardianv1alpha1.AWSSecretGuardian{guardian, other, guardian} {
+		if _, err := r.AWSClientsFor(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(stsClient.inputs) != 2 || aws.StringValue(stsClient.inputs[1].RoleSessionName) != "default-other" {
+		t.Fatalf("%d AssumeRole calls, want a session of its own for each guardian", len(stsClient.inputs))
+	}
+
+	duration := int64(900)
+	guardian.Spec.Auth.AssumeRole.DurationSeconds = &duration
+	if _, err := r.AWSClientsFor(ctx, guardian); err != nil || len(stsClient.inputs) != 3 {
+		t.Fatalf("%v, %d AssumeRole calls, want the role assumed again after a spec change", err, len(stsClient.inputs))
+	}
+
+	// the guardian is deleted, its assumed role is dropped from the cache
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: guardian.Namespace, Name: guardian.Name}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AWSClientsFor(ctx, guardian); err != nil || len(stsClient.inputs) != 4 {
+		t.Errorf("%v, %d AssumeRole calls, want the role assumed again after the deletion", err, len(stsClient.inputs))
+	}
+	if _, err := r.AWSClientsFor(ctx, other); err != nil || len(stsClient.inputs) != 4 {
+		t.Errorf("%v, %d AssumeRole calls, want the cached role of the other guardian", err, len(stsClient.inputs))
+	}
+}
+
+func TestReconcileAssumeRoleFailed(t *testing.T) {
+	credentialsSecret := newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"})
+	guardian := newTestGuardian()
+	guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"}
+	guardian.Spec.Auth = &secretguardianv1alpha1.AuthSpec{AssumeRole: &secretguardianv1alpha1.AssumeRoleSpec{RoleARN: "arn:aws:iam::210987654321:role/secret-guardian"}}
+	stsClient := &mockSTS{err: awserr.New(sts.ErrCodeMalformedPolicyDocumentException, "access denied", nil)}
+	r := newAWSTestReconciler(t, stsClient, guardian, credentialsSecret)
+
+	if _, err := r.AWSClientsFor(context.Background(), guardian); !errors.Is(err, ErrAssumeRoleFailed) {
+		t.Errorf("%v, want %v", err, ErrAssumeRoleFailed)
+	}
+	result, got, secret := reconcileGuardian(t, r)
+	if secret != nil {
+		t.Error("a secret was written without the assumed role")
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionAWSReachable)
+	if condition == nil || condition.Reason != ReasonAssumeRoleFailed || result.RequeueAfter == 0 {
+		t.Errorf("AWSReachable condition %v, requeue %s, want AssumeRoleFailed with a requeue", condition, result.RequeueAfter)
+	}
+}
+
+func TestGuardiansForCredentialsSecret(t *testing.T) {
+	newGuardian := func(namespace, name string, credentialsRef string) *secretguardianv1alpha1.AWSSecretGuardian {
+		guardian := newTestGuardian()
+		guardian.Namespace, guardian.Name, guardian.UID = namespace, name, types.UID(name+"-uid")
+		if credentialsRef != "" {
+			guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: credentialsRef}
+		}
+		return guardian
+	}
+	podIdentity := newGuardian("team-a", "pod-identity", "team-creds")
+	podIdentity.Spec.Auth = &secretguardianv1alpha1.AuthSpec{Type: secretguardianv1alpha1.AuthTypePodIdentity}
+	vaultToken := newGuardian("team-a", "vault-token", "")
+	vaultToken.Spec.Provider = secretguardianv1alpha1.ProviderVault
+	vaultToken.Spec.Vault = &secretguardianv1alpha1.VaultSpec{Auth: secretguardianv1alpha1.VaultAuthSpec{
+		Method:         secretguardianv1alpha1.VaultAuthToken,
+		TokenSecretRef: &secretguardianv1alpha1.SecretKeyReference{Name: "vault-token", Key: "token"},
+	}}
+	r := newTestReconciler(t, nil,
+		newGuardian("default", "uses-default", ""),
+		newGuardian("team-a", "uses-default", ""),
+		newGuardian("team-a", "team-creds", "team-creds"),
+		newGuardian("team-b", "team-creds", "team-creds"),
+		podIdentity,
+		vaultToken,
+	)
+	r.DefaultCredentialsSecret = types.NamespacedName{Namespace: "secret-guardian-system", Name: "aws-default"}
+
+	cases := map[string]struct {
+		secret types.NamespacedName
+		want   []string
+	}{
+		"default Secret":                            {secret: r.DefaultCredentialsSecret, want: []string{"default/uses-default", "team-a/uses-default"}},
+		"credentialsRef":                            {secret: types.NamespacedName{Namespace: "team-a", Name: "team-creds"}, want: []string{"team-a/team-creds"}},
+		"credentialsRef in another namespace":       {secret: types.NamespacedName{Namespace: "team-b", Name: "team-creds"}, want: []string{"team-b/team-creds"}},
+		"Vault token":                               {secret: types.NamespacedName{Namespace: "team-a", Name: "vault-token"}, want: []string{"team-a/vault-token"}},
+		"default Secret name in a tenant namespace": {secret: types.NamespacedName{Namespace: "team-a", Name: "aws-default"}},
+		"unrelated Secret":                          {secret: types.NamespacedName{Namespace: "team-a", Name: "other"}},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			secret := newCredentialsSecret(tt.secret.Namespace, tt.secret.Name, nil)
+			var got []string
+			for _, request := range r.GuardiansForCredentialsSecret(context.Background(), secret) {
+				got = append(got, request.String())
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("requests %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseCredentialsForInvalidatesDeletedSecret(t *testing.T) {
+	credentialsSecret := newCredentialsSecret("default", "team-creds", map[string]string{"access-key": "AKID", "secret-access-key": "SECRET"})
+	guardian := newTestGuardian()
+	guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"}
+	r := newAWSTestReconciler(t, &mockSTS{}, credentialsSecret)
+
+	ctx := context.Background()
+	identity, _, err := r.BaseCredentialsFor(ctx, guardian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AWSClientsFor(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(ctx, credentialsSecret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AWSClientsFor(ctx, guardian); !apierrors.IsNotFound(err) {
+		t.Fatalf("%v, want NotFound", err)
+	}
+	var created bool
+	if _, err := r.awsClients.GetOrCreate(guardian.Spec.Region, identity, func() (*credentials.Credentials, error) {
+		created = true
+		return credentials.AnonymousCredentials, nil
+	}); err != nil || !created {
+		t.Errorf("the clients of the deleted Secret are still cached")
+	}
+}
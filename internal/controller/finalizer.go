@@ -0,0 +1,125 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+)
+
+// Finalizer is set on the guardians whose secret is deleted from the store with them
+const Finalizer = "secretguardian.omerap12.com/finalizer"
+
+// DefaultDeletionTimeout of a guardian without a deletion timeout, a deleted guardian is never blocked forever
+const DefaultDeletionTimeout = 24 * time.Hour
+
+// function to check if the secret of the guardian is deleted from the store when the guardian is deleted
+// return true if the deletion policy is Delete or ForceDeleteWithoutRecovery
+func DeletesStoreSecret(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) bool {
+	policy := awsSecretGuardian.Spec.DeletionPolicy
+	return policy == secretguardianv1alpha1.DeletionPolicyDelete || policy == secretguardianv1alpha1.DeletionPolicyForceDeleteWithoutRecovery
+}
+
+// function to add or remove the finalizer of the guardian according to its deletion policy
+// a guardian that retains its secret has no finalizer, so it is never blocked by an unreachable store
+// return an error if the guardian can not be updated
+func (r *AWSSecretGuardianReconciler) EnsureFinalizer(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) error {
+	var changed bool
+	if DeletesStoreSecret(awsSecretGuardian) {
+		changed = controllerutil.AddFinalizer(awsSecretGuardian, Finalizer)
+	} else {
+		changed = controllerutil.RemoveFinalizer(awsSecretGuardian, Finalizer)
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, awsSecretGuardian)
+}
+
+// function to clean up a deleted guardian before its finalizer is removed
+// the secret is deleted from the store according to the deletion policy, a secret already deleted is not an error
+// if the deletion fails, the guardian is marked as failed and the deletion is retried,
+// until the deletion timeout of the guardian, DefaultDeletionTimeout when omitted, is reached, the secret is then left in the store
+// return the result of the reconcile
+func (r *AWSSecretGuardianReconciler) FinalizeGuardian(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(awsSecretGuardian, Finalizer) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.DeleteStoreSecret(ctx, awsSecretGuardian); err != nil {
+		logger.Info(fmt.Sprintf("Error deleting secret %s from the store: %s", awsSecretGuardian.Spec.SecretName(), err))
+		timeout := DefaultDeletionTimeout
+		if awsSecretGuardian.Spec.DeletionTimeout != nil {
+			timeout = awsSecretGuardian.Spec.DeletionTimeout.Duration
+		}
+		if r.now().Sub(awsSecretGuardian.DeletionTimestamp.Time) < timeout {
+			SetFailed(awsSecretGuardian, ReasonDeletionFailed, err.Error())
+			r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+			return ctrl.Result{RequeueAfter: RequeueAfterTime * time.Second}, nil
+		}
+		logger.Info(fmt.Sprintf("Deletion timeout of AWSSecretGuardian %s/%s reached, secret %s is left in the store", awsSecretGuardian.Namespace, awsSecretGuardian.Name, awsSecretGuardian.Spec.SecretName()))
+	}
+	controllerutil.RemoveFinalizer(awsSecretGuardian, Finalizer)
+	if err := r.Update(ctx, awsSecretGuardian); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.awsClients.InvalidateOwner(client.ObjectKeyFromObject(awsSecretGuardian).String()) // the assumed role of the guardian is not used anymore
+	return ctrl.Result{}, nil
+}
+
+// function to delete the secret of the guardian from the store according to its deletion policy
+// a secret the guardian does not own is never deleted
+// return nil if the secret is deleted, retained, not owned or does not exist
+func (r *AWSSecretGuardianReconciler) DeleteStoreSecret(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) error {
+	if !DeletesStoreSecret(awsSecretGuardian) {
+		return nil
+	}
+	store, err := r.SecretStoreFor(ctx, awsSecretGuardian)
+	if err != nil {
+		return err
+	}
+	info, err := store.Describe(ctx, awsSecretGuardian.Spec.SecretName())
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !OwnsStoreSecret(awsSecretGuardian, info) { // e.g. the guardian was refused the adoption of the secret
+		logger.Info(fmt.Sprintf("Secret %s is not owned by AWSSecretGuardian %s, it is left in the store", awsSecretGuardian.Spec.SecretName(), GuardianOwner(awsSecretGuardian)))
+		return nil
+	}
+	options := secretstore.DeleteOptions{Force: awsSecretGuardian.Spec.DeletionPolicy == secretguardianv1alpha1.DeletionPolicyForceDeleteWithoutRecovery}
+	if awsSecretGuardian.Spec.RecoveryWindowInDays != nil {
+		options.RecoveryWindowInDays = *awsSecretGuardian.Spec.RecoveryWindowInDays
+	}
+	err = store.Delete(ctx, awsSecretGuardian.Spec.SecretName(), options)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return nil
+	}
+	if err == nil {
+		logger.Info(fmt.Sprintf("Secret %s deleted from the store", awsSecretGuardian.Spec.SecretName()))
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+// function to delete the test guardian and reconcile its deletion
+// return the result of the reconcile and the guardian, nil once it is gone
+func deleteGuardian(t *testing.T, r *AWSSecretGuardianReconciler) (ctrl.Result, *secretguardianv1alpha1.AWSSecretGuardian) {
+	t.Helper()
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "guardian"}
+	guardian := &secretguardianv1alpha1.AWSSecretGuardian{}
+	if err := r.Get(ctx, key, guardian); err != nil {
+		t.Fatal(err)
+	}
+	if guardian.DeletionTimestamp.IsZero() {
+		if err := r.Delete(ctx, guardian); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if err := r.Get(ctx, key, guardian); apierrors.IsNotFound(err) {
+		return result, nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return result, guardian
+}
+
+func TestDeletionPolicyDelete(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.DeletionPolicy = secretguardianv1alpha1.DeletionPolicyDelete
+	recoveryWindow := int64(7)
+	guardian.Spec.RecoveryWindowInDays = &recoveryWindow
+	r := newTestReconciler(t, store, guardian)
+
+	_, got, _ := reconcileGuardian(t, r)
+	if !controllerutil.ContainsFinalizer(got, Finalizer) {
+		t.Fatalf("finalizers %v, want %s", got.Finalizers, Finalizer)
+	}
+	if _, got := deleteGuardian(t, r); got != nil {
+		t.Errorf("guardian kept its finalizers %v after the secret was deleted", got.Finalizers)
+	}
+	secret := store.Secret("db-password")
+	if !secret.Deleted || secret.DeleteOptions.Force || secret.DeleteOptions.RecoveryWindowInDays != 7 {
+		t.Errorf("store secret deleted %v with %+v, want a 7 days recovery window", secret.Deleted, secret.DeleteOptions)
+	}
+}
+
+func TestDeletionPolicyRetain(t *testing.T) {
+	store := fake.New()
+	r := newTestReconciler(t, store, newTestGuardian())
+
+	_, got, _ := reconcileGuardian(t, r)
+	if len(got.Finalizers) != 0 {
+		t.Errorf("finalizers %v on a guardian retaining its secret", got.Finalizers)
+	}
+	if _, got := deleteGuardian(t, r); got != nil || store.Secret("db-password").Deleted {
+		t.Error("a retained secret was deleted from the store")
+	}
+}
+
+func TestDeletionFailureAndTimeout(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.DeletionPolicy = secretguardianv1alpha1.DeletionPolicyForceDeleteWithoutRecovery
+	r := newTestReconciler(t, store, guardian)
+	reconcileGuardian(t, r)
+
+	store.Errors["Delete"] = errors.New("access denied")
+	result, got := deleteGuardian(t, r)
+	if got == nil || result.RequeueAfter == 0 {
+		t.Fatal("guardian deleted although its secret could not be deleted")
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionReady)
+	if condition == nil || condition.Reason != ReasonDeletionFailed {
+		t.Errorf("Ready condition %v, want DeletionFailed", condition)
+	}
+
+	got.Spec.DeletionTimeout = &metav1.Duration{Duration: time.Nanosecond} // the escape hatch
+	if err := r.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := deleteGuardian(t, r); got != nil {
+		t.Error("guardian kept after its deletion timeout")
+	}
+	if store.Secret("db-password").Deleted {
+		t.Error("secret deleted although the store refused it")
+	}
+}
+
+func TestDeletionTimeoutAfterCredentialsDeleted(t *testing.T) {
+	cases := map[string]struct {
+		deleted time.Duration // how long ago the guardian was deleted
+		removed bool
+	}{
+		"before the default timeout": {time.Minute, false},
+		"after the default timeout":  {DefaultDeletionTimeout + time.Minute, true},
+	}
+	for name, c := range cases {
+		guardian := newTestGuardian()
+		guardian.Spec.DeletionPolicy = secretguardianv1alpha1.DeletionPolicyDelete
+		guardian.Spec.CredentialsRef = &secretguardianv1alpha1.CredentialsReference{Name: "team-creds"} // deleted before the guardian
+		guardian.Finalizers = []string{Finalizer}
+		deletionTimestamp := metav1.NewTime(time.Now().Add(-c.deleted))
+		guardian.DeletionTimestamp = &deletionTimestamp
+		r := newAWSTestReconciler(t, &mockSTS{}, guardian)
+
+		result, got := deleteGuardian(t, r)
+		if c.removed {
+			if got != nil {
+				t.Errorf("%s: guardian kept after the default deletion timeout", name)
+			}
+			continue
+		}
+		if got == nil || result.RequeueAfter == 0 || !controllerutil.ContainsFinalizer(got, Finalizer) {
+			t.Errorf("%s: guardian %v, requeue %s, want the deletion retried", name, got, result.RequeueAfter)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+)
+
+// function to compute the hashes of the value of a key
+// the username of an htpasswd line is read from the values when it references a key
+// a hash of the previous value is kept while the value and the username it was computed from are unchanged,
+// so adding a hash computes only the new one
+// return the hashes by key of the secret
+func HashValues(key *secretguardianv1alpha1.KeySpec, values map[string]string, previous map[string]string) (map[string]string, error) {
+	hashes := make(map[string]string, len(key.Hashes))
+	value := values[key.Name]
+	for i := range key.Hashes {
+		hash := &key.Hashes[i]
+		if hashed, ok := previous[key.HashName(hash)]; ok && previous[key.Name] == value && (hash.UsernameKey == "" || previous[hash.UsernameKey] == values[hash.UsernameKey]) {
+			hashes[key.HashName(hash)] = hashed
+			continue
+		}
+		cost := 0
+		if hash.Cost != nil {
+			cost = *hash.Cost
+		}
+		var hashed string
+		var err error
+		switch hash.Algorithm {
+		case secretguardianv1alpha1.HashBCrypt:
+			hashed, err = generator.BCrypt(value, cost)
+		case secretguardianv1alpha1.HashArgon2id:
+			hashed, err = generator.Argon2id(value)
+		case secretguardianv1alpha1.HashSHA256:
+			hashed = generator.SHA256(value)
+		case secretguardianv1alpha1.HashHtpasswd:
+			username := hash.Username
+			if hash.UsernameKey != "" {
+				var ok bool
+				if username, ok = values[hash.UsernameKey]; !ok {
+					return nil, fmt.Errorf("%w: htpasswd usernameKey %s is not a key of the secret", ErrInvalidKeys, hash.UsernameKey)
+				}
+			}
+			hashed, err = generator.Htpasswd(username, value, cost)
+		default:
+			return nil, fmt.Errorf("%w: unknown hash algorithm %q", ErrInvalidKeys, hash.Algorithm)
+		}
+		if errors.Is(err, bcrypt.ErrPasswordTooLong) || errors.Is(err, generator.ErrInvalidUsername) { // retrying does not help until the spec is changed
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKeys, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		hashes[key.HashName(hash)] = hashed
+	}
+	return hashes, nil
+}
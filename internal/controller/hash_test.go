@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/apimachinery/pkg/types"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+func TestReconcileWritesHashes(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.TTL = 0 // every reconcile rotates
+	cost, admin := bcrypt.MinCost, "admin"
+	guardian.Spec.Keys = []secretguardianv1alpha1.KeySpec{
+		{Name: "username", Value: &admin},
+		{Name: "password", Hashes: []secretguardianv1alpha1.HashSpec{
+			{Algorithm: secretguardianv1alpha1.HashBCrypt, Cost: &cost},
+			{Algorithm: secretguardianv1alpha1.HashSHA256, Name: "password-sha"},
+			{Algorithm: secretguardianv1alpha1.HashHtpasswd, Cost: &cost, UsernameKey: "username", Name: "auth"},
+		}},
+	}
+	r := newTestReconciler(t, store, guardian)
+	clock := newTestClock(r)
+
+	_, _, secret := reconcileGuardian(t, r)
+	password := string(secret.Data["password"])
+	if err := bcrypt.CompareHashAndPassword(secret.Data["password.bcrypt"], []byte(password)); err != nil {
+		t.Errorf("password.bcrypt does not match the password: %v", err)
+	}
+	if string(secret.Data["password-sha"]) != generator.SHA256(password) {
+		t.Error("password-sha does not match the password")
+	}
+	username, hash, _ := strings.Cut(string(secret.Data["auth"]), ":")
+	if username != "admin" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		t.Errorf("htpasswd line %q does not match admin and the password", secret.Data["auth"])
+	}
+
+	clock.Advance(time.Second) // the rotation annotation has a precision of one second
+	_, _, rotated := reconcileGuardian(t, r)
+	if string(rotated.Data["password"]) == password || string(rotated.Data["password-sha"]) != generator.SHA256(string(rotated.Data["password"])) {
+		t.Error("the hashes were not computed from the rotated password")
+	}
+}
+
+func TestReconcileAddsHashWithoutRotating(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	rotate := false
+	guardian.Spec.Keys = []secretguardianv1alpha1.KeySpec{{Name: "username", Rotate: &rotate}, {Name: "password"}}
+	r := newTestReconciler(t, store, guardian)
+	_, first, secret := reconcileGuardian(t, r)
+
+	ctx := context.Background()
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Keys[0].Hashes = []secretguardianv1alpha1.HashSpec{{Algorithm: secretguardianv1alpha1.HashSHA256}}
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, second, hashed := reconcileGuardian(t, r)
+	if string(hashed.Data["username"]) != string(secret.Data["username"]) || string(hashed.Data["password"]) != string(secret.Data["password"]) {
+		t.Error("adding a hash regenerated the values of the secret")
+	}
+	if string(hashed.Data["username.sha256"]) != generator.SHA256(string(secret.Data["username"])) {
+		t.Errorf("username.sha256 %q, want the hash of the kept username", hashed.Data["username.sha256"])
+	}
+	if !second.Status.LastRotationTime.Equal(first.Status.LastRotationTime) || hashed.Annotations[RotationAnnotation] != secret.Annotations[RotationAnnotation] {
+		t.Errorf("adding a hash changed the rotation time: %s -> %s", first.Status.LastRotationTime, second.Status.LastRotationTime)
+	}
+}
+
+func TestHashValuesRefusesLongBCryptValues(t *testing.T) {
+	key := &secretguardianv1alpha1.KeySpec{Name: "password", Hashes: []secretguardianv1alpha1.HashSpec{{Algorithm: secretguardianv1alpha1.HashBCrypt}}}
+	if _, err := HashValues(key, map[string]string{"password": strings.Repeat("a", 73)}, nil); !errors.Is(err, ErrInvalidKeys) {
+		t.Errorf("bcrypt of 73 bytes: %v, want ErrInvalidKeys", err)
+	}
+	key.Hashes[0] = secretguardianv1alpha1.HashSpec{Algorithm: secretguardianv1alpha1.HashHtpasswd, UsernameKey: "missing"}
+	if _, err := HashValues(key, map[string]string{"password": "a"}, nil); !errors.Is(err, ErrInvalidKeys) {
+		t.Errorf("htpasswd with a missing usernameKey: %v, want ErrInvalidKeys", err)
+	}
+	key.Hashes[0] = secretguardianv1alpha1.HashSpec{Algorithm: secretguardianv1alpha1.HashHtpasswd, UsernameKey: "username"}
+	if _, err := HashValues(key, map[string]string{"username": "ad:min", "password": "a"}, nil); !errors.Is(err, ErrInvalidKeys) {
+		t.Errorf("htpasswd with a username containing ':': %v, want ErrInvalidKeys", err)
+	}
+}
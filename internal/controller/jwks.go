@@ -0,0 +1,223 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+)
+
+// Defaults of a JWKS
+const (
+	DefaultJWKSPreviousKeys = 1
+	DefaultJWKSOverlap      = 24 * time.Hour
+)
+
+// function to add the public key of a new private key to a JWKS
+// the previous current key is kept until the end of the overlap, the expired and extra previous keys are pruned
+// return the JWKS as JSON, with the new key first
+func RotateJWKS(spec *secretguardianv1alpha1.JWKSSpec, previousJWKS string, privateKeyPEM string, now time.Time) (string, error) {
+	privateKey, err := generator.ParsePrivateKeyPEM(privateKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	current, err := generator.PublicJWK(privateKey.Public())
+	if err != nil {
+		return "", err
+	}
+	jwks := &generator.JWKS{Keys: []generator.JWK{*current}}
+	if previousJWKS != "" {
+		previous := &generator.JWKS{}
+		if err := json.Unmarshal([]byte(previousJWKS), previous); err != nil {
+			return "", fmt.Errorf("previous JWKS: %w", err)
+		}
+		for _, key := range previous.Keys {
+			if key.Kid == current.Kid {
+				continue
+			}
+			if key.Exp == 0 { // the key signed until now, verifiers still need it for the overlap
+				key.Exp = now.Add(JWKSOverlap(spec)).Unix()
+			}
+			jwks.Keys = append(jwks.Keys, key)
+		}
+	}
+	pruned, _ := PruneJWKS(spec, jwks, now)
+	return marshalJWKS(pruned)
+}
+
+// function to remove the previous keys of a JWKS whose overlap ended, and the previous keys above the limit
+// return the pruned JWKS and true if a key was removed
+func PruneJWKS(spec *secretguardianv1alpha1.JWKSSpec, jwks *generator.JWKS, now time.Time) (*generator.JWKS, bool) {
+	pruned := &generator.JWKS{Keys: []generator.JWK{}}
+	previousKeys := 0
+	for _, key := range jwks.Keys {
+		if key.Exp != 0 {
+			if now.Unix() >= key.Exp || previousKeys >= JWKSPreviousKeys(spec) {
+				continue
+			}
+			previousKeys++
+		}
+		pruned.Keys = append(pruned.Keys, key)
+	}
+	return pruned, len(pruned.Keys) != len(jwks.Keys)
+}
+
+// function to compute when the first previous key of the JWKS of the keys is pruned
+// return the prune time, zero if no JWKS has a previous key
+func JWKSPruneTime(keys []secretguardianv1alpha1.KeySpec, data map[string][]byte) time.Time {
+	var pruneTime time.Time
+	for _, key := range keys {
+		name := key.JWKSName()
+		if name == "" {
+			continue
+		}
+		jwks := &generator.JWKS{}
+		if err := json.Unmarshal(data[name], jwks); err != nil {
+			continue
+		}
+		for _, jwk := range jwks.Keys {
+			if jwk.Exp == 0 {
+				continue
+			}
+			if keyPruneTime := time.Unix(jwk.Exp, 0).UTC(); pruneTime.IsZero() || keyPruneTime.Before(pruneTime) {
+				pruneTime = keyPruneTime
+			}
+		}
+	}
+	return pruneTime
+}
+
+// function to prune the expired previous keys of the JWKS in the value of the secret
+// return true if a key was removed
+func PruneJWKSValue(keys []secretguardianv1alpha1.KeySpec, value map[string]string, now time.Time) (bool, error) {
+	changed := false
+	for i := range keys {
+		key := &keys[i]
+		name := key.JWKSName()
+		if name == "" {
+			continue
+		}
+		jwks := &generator.JWKS{}
+		if err := json.Unmarshal([]byte(value[name]), jwks); err != nil {
+			return false, fmt.Errorf("JWKS %s: %w", name, err)
+		}
+		pruned, keyChanged := PruneJWKS(key.KeyPair.JWKS, jwks, now)
+		if !keyChanged {
+			continue
+		}
+		prunedJSON, err := marshalJWKS(pruned)
+		if err != nil {
+			return false, err
+		}
+		value[name], changed = prunedJSON, true
+	}
+	return changed, nil
+}
+
+// function to apply the ConfigMaps of the JWKS of the keys with the value of the secret
+// the ConfigMaps are applied on every reconcile, so a deleted or edited ConfigMap is published again,
+// and are controlled by the guardian, so they are deleted with it
+// keys publishing to the same ConfigMap are applied together, an apply removes the keys it omits
+// return an error if a ConfigMap can not be applied
+func (r *AWSSecretGuardianReconciler) JWKSConfigMapHandler(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, value map[string]string) error {
+	configMaps := map[string]*corev1.ConfigMap{}
+	var names []string // the ConfigMaps in the order of the keys
+	for i := range awsSecretGuardian.Spec.Keys {
+		key := &awsSecretGuardian.Spec.Keys[i]
+		name := key.JWKSName()
+		if name == "" {
+			continue
+		}
+		published, err := PublishedJWKS(value[name])
+		if err != nil {
+			return fmt.Errorf("JWKS %s: %w", name, err)
+		}
+		configMap, ok := configMaps[key.KeyPair.JWKS.ConfigMapName]
+		if !ok {
+			configMap = &corev1.ConfigMap{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}, // required by an apply
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            key.KeyPair.JWKS.ConfigMapName,
+					Namespace:       awsSecretGuardian.Namespace,
+					OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(awsSecretGuardian, secretguardianv1alpha1.GroupVersion.WithKind("AWSSecretGuardian"))},
+				},
+				Data: map[string]string{},
+			}
+			configMaps[configMap.Name] = configMap
+			names = append(names, configMap.Name)
+		}
+		configMap.Data[name] = published
+	}
+	for _, name := range names {
+		// the apply only holds fields generated by the controller, so their ownership is always taken back
+		err := r.Patch(ctx, configMaps[name], client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership)
+		if err != nil {
+			return fmt.Errorf("JWKS ConfigMap %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// function to get the JWKS published to verifiers from the JWKS of the secret
+// the exp member records when a previous key is pruned, it is only used by the controller and is not a JWK member
+// return the JWKS as JSON without exp
+func PublishedJWKS(jwksJSON string) (string, error) {
+	jwks := &generator.JWKS{}
+	if err := json.Unmarshal([]byte(jwksJSON), jwks); err != nil {
+		return "", err
+	}
+	for i := range jwks.Keys {
+		jwks.Keys[i].Exp = 0
+	}
+	return marshalJWKS(jwks)
+}
+
+// function to get the maximum number of previous keys of a JWKS
+// return the previousKeys of the spec, DefaultJWKSPreviousKeys if not set
+func JWKSPreviousKeys(spec *secretguardianv1alpha1.JWKSSpec) int {
+	if spec.PreviousKeys == nil {
+		return DefaultJWKSPreviousKeys
+	}
+	return *spec.PreviousKeys
+}
+
+// function to get how long a previous key stays in a JWKS
+// return the overlap of the spec, DefaultJWKSOverlap if not set
+func JWKSOverlap(spec *secretguardianv1alpha1.JWKSSpec) time.Duration {
+	if spec.Overlap == nil {
+		return DefaultJWKSOverlap
+	}
+	return spec.Overlap.Duration
+}
+
+// function to encode a JWKS
+func marshalJWKS(jwks *generator.JWKS) (string, error) {
+	jwksJSON, err := json.Marshal(jwks)
+	if err != nil {
+		return "", err
+	}
+	return string(jwksJSON), nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+// function to decode the JWKS published in the ConfigMap of the test guardian
+// return the JWKS
+func publishedJWKS(t *testing.T, r *AWSSecretGuardianReconciler) *generator.JWKS {
+	t.Helper()
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "jwks"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(configMap.Data["jwks.json"], `"exp"`) {
+		t.Errorf("published JWKS %s, want no exp member", configMap.Data["jwks.json"])
+	}
+	return decodeJWKS(t, configMap.Data["jwks.json"])
+}
+
+// function to decode a JWKS
+// return the JWKS
+func decodeJWKS(t *testing.T, jwksJSON string) *generator.JWKS {
+	t.Helper()
+	jwks := &generator.JWKS{}
+	if err := json.Unmarshal([]byte(jwksJSON), jwks); err != nil {
+		t.Fatal(err)
+	}
+	return jwks
+}
+
+func TestReconcileJWKSOverlap(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Keys = []secretguardianv1alpha1.KeySpec{{
+		Name:      "signing",
+		Generator: secretguardianv1alpha1.GeneratorKeyPair,
+		KeyPair: &secretguardianv1alpha1.KeyPairSpec{
+			Algorithm: secretguardianv1alpha1.KeyAlgorithmEd25519,
+			JWKS:      &secretguardianv1alpha1.JWKSSpec{ConfigMapName: "jwks", Overlap: &metav1.Duration{Duration: time.Second}},
+		},
+	}}
+	r := newTestReconciler(t, store, guardian)
+	clock := newTestClock(r)
+
+	reconcileGuardian(t, r)
+	first := publishedJWKS(t, r)
+	if len(first.Keys) != 1 || first.Keys[0].Exp != 0 {
+		t.Fatalf("first JWKS %+v, want the current key only", first)
+	}
+
+	ctx := context.Background()
+	setTTL := func(ttl int) {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+			t.Fatal(err)
+		}
+		guardian.Spec.TTL = ttl
+		if err := r.Update(ctx, guardian); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setTTL(0) // rotates right away
+	_, _, secret := reconcileGuardian(t, r)
+	setTTL(3600)
+	_, rotated, _ := reconcileGuardian(t, r)
+	second := decodeJWKS(t, store.Current("db-password")["jwks.json"]) // the exp of the previous key is only kept in the secret
+	if len(second.Keys) != 2 || second.Keys[1].Kid != first.Keys[0].Kid || second.Keys[1].Exp == 0 || second.Keys[0].Exp != 0 {
+		t.Fatalf("second JWKS %+v, want the new key and the previous one until the end of the overlap", second)
+	}
+	if published := publishedJWKS(t, r); len(published.Keys) != 2 || published.Keys[0].Kid != second.Keys[0].Kid || published.Keys[1].Kid != second.Keys[1].Kid {
+		t.Fatalf("published JWKS %+v, want the keys of the secret", published)
+	}
+	if string(secret.Data["jwks.json"]) != store.Current("db-password")["jwks.json"] {
+		t.Error("the JWKS of the k8s secret and of the store differ")
+	}
+	if next := rotated.Status.NextRotationTime; next == nil || next.Unix() != second.Keys[1].Exp {
+		t.Errorf("next rotation %v, want the end of the overlap %d", next, second.Keys[1].Exp)
+	}
+	clock.Advance(time.Unix(second.Keys[1].Exp, 0).Sub(clock.now)) // the TTL is not reached, the previous key is still pruned
+	_, pruned, prunedSecret := reconcileGuardian(t, r)
+	third := publishedJWKS(t, r)
+	if len(third.Keys) != 1 || third.Keys[0].Kid != second.Keys[0].Kid {
+		t.Fatalf("third JWKS %+v, want the previous key pruned and the current one kept", third)
+	}
+	if string(prunedSecret.Data["signing"]) != string(secret.Data["signing"]) {
+		t.Error("pruning the JWKS rotated the private key")
+	}
+	if !pruned.Status.LastRotationTime.Equal(rotated.Status.LastRotationTime) || prunedSecret.Annotations[RotationAnnotation] != secret.Annotations[RotationAnnotation] {
+		t.Errorf("pruning the JWKS changed the rotation time: %s -> %s", rotated.Status.LastRotationTime, pruned.Status.LastRotationTime)
+	}
+}
+
+func TestRotateJWKSLimitsPreviousKeys(t *testing.T) {
+	previousKeys := 1
+	spec := &secretguardianv1alpha1.JWKSSpec{ConfigMapName: "jwks", PreviousKeys: &previousKeys}
+	now := time.Now()
+	var jwks string
+	var kids []string
+	for i := 0; i < 3; i++ {
+		keyPair, err := generator.GenerateKeyPair(generator.ECDSA, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwks, err = RotateJWKS(spec, jwks, keyPair.PrivateKeyPEM, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, err := generator.PublicJWK(keyPair.PrivateKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		kids = append(kids, jwk.Kid)
+	}
+	decoded := &generator.JWKS{}
+	if err := json.Unmarshal([]byte(jwks), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Keys) != 2 || decoded.Keys[0].Kid != kids[2] || decoded.Keys[1].Kid != kids[1] {
+		t.Errorf("JWKS %s, want the current key and the last previous one", jwks)
+	}
+	if expires := time.Unix(decoded.Keys[1].Exp, 0); expires.Before(now.Add(DefaultJWKSOverlap - time.Second)) {
+		t.Errorf("previous key removed at %s, want after the default overlap", expires)
+	}
+}
+
+func TestReconcileJWKSConfigMap(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Keys = []secretguardianv1alpha1.KeySpec{{
+		Name:      "signing",
+		Generator: secretguardianv1alpha1.GeneratorKeyPair,
+		KeyPair: &secretguardianv1alpha1.KeyPairSpec{
+			Algorithm: secretguardianv1alpha1.KeyAlgorithmEd25519,
+			JWKS:      &secretguardianv1alpha1.JWKSSpec{ConfigMapName: "jwks"},
+		},
+	}}
+	r := newTestReconciler(t, store, guardian)
+	ctx := context.Background()
+
+	reconcileGuardian(t, r)
+	key := types.NamespacedName{Namespace: "default", Name: "jwks"}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if owner := metav1.GetControllerOf(configMap); owner == nil || owner.UID != guardian.UID || owner.Kind != "AWSSecretGuardian" {
+		t.Errorf("ConfigMap controller %+v, want the guardian", owner)
+	}
+	published := publishedJWKS(t, r)
+
+	if err := r.Delete(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+	reconcileGuardian(t, r)
+	if republished := publishedJWKS(t, r); len(republished.Keys) != 1 || republished.Keys[0].Kid != published.Keys[0].Kid {
+		t.Errorf("republished JWKS %+v, want the current key %s", republished, published.Keys[0].Kid)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/generator"
+)
+
+// Reasons used in the AWSSecretGuardian status conditions
+const (
+	ReasonReconciled               = "Reconciled"
+	ReasonAuthenticated            = "Authenticated"
+	ReasonCredentialsNotFound      = "CredentialsNotFound"
+	ReasonCredentialsRefForbidden  = "CredentialsRefForbidden"
+	ReasonAuthTypeForbidden        = "AuthTypeForbidden"
+	ReasonVaultForbidden           = "VaultForbidden"
+	ReasonAssumeRoleFailed         = "AssumeRoleFailed"
+	ReasonAWSAuthFailed            = "AWSAuthFailed"
+	ReasonAWSError                 = "AWSError"
+	ReasonRotationSucceeded        = "RotationSucceeded"
+	ReasonRotationFailed           = "RotationFailed"
+	ReasonInvalidPasswordPolicy    = "InvalidPasswordPolicy"
+	ReasonInvalidKeys              = "InvalidKeys"
+	ReasonInvalidTemplate          = "InvalidTemplate"
+	ReasonInvalidTarget            = "InvalidTarget"
+	ReasonTargetNamespaceForbidden = "TargetNamespaceForbidden"
+	ReasonDeletionFailed           = "DeletionFailed"
+	ReasonAdoptionRefused          = "AdoptionRefused"
+	ReasonIssuerExpiring           = "IssuerExpiring"
+	ReasonK8SSecretConflict        = "K8SSecretConflict"
+)
+
+// function to get the reason of an error caused by the spec of the guardian
+// return an empty reason for errors that may succeed on retry
+func InvalidSpecReason(err error) string {
+	switch {
+	case errors.Is(err, generator.ErrInvalidPolicy):
+		return ReasonInvalidPasswordPolicy
+	case errors.Is(err, ErrInvalidKeys):
+		return ReasonInvalidKeys
+	case errors.Is(err, ErrInvalidTemplate):
+		return ReasonInvalidTemplate
+	case errors.Is(err, ErrInvalidTarget):
+		return ReasonInvalidTarget
+	case errors.Is(err, ErrTargetNamespaceForbidden):
+		return ReasonTargetNamespaceForbidden
+	}
+	return ""
+}
+
+// function to set a condition on the AWSSecretGuardian status
+// the observed generation of the condition is taken from the object
+func SetCondition(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&awsSecretGuardian.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: awsSecretGuardian.Generation,
+	})
+}
+
+// function to mark the AWSSecretGuardian as failed
+// sets Ready to false and Degraded to true with the given reason and message
+func SetFailed(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, reason string, message string) {
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+}
+
+// function to mark the AWSSecretGuardian as successfully reconciled
+// sets Ready to true, Degraded to false and records the observed generation
+func SetSucceeded(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) {
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionReady, metav1.ConditionTrue, ReasonReconciled, "Secret is in sync")
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionDegraded, metav1.ConditionFalse, ReasonReconciled, "Secret is in sync")
+	awsSecretGuardian.Status.ObservedGeneration = awsSecretGuardian.Generation
+}
+
+// function to mark the AWSSecretGuardian as unable to reach AWS
+// used when the controller credentials can not be loaded or verified
+func (r *AWSSecretGuardianReconciler) SetAWSUnreachable(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, reason string, message string) {
+	SetCondition(awsSecretGuardian, secretguardianv1alpha1.ConditionAWSReachable, metav1.ConditionFalse, reason, message)
+	SetFailed(awsSecretGuardian, reason, message)
+	r.UpdateGuardianStatus(ctx, awsSecretGuardian)
+}
+
+// function to write the AWSSecretGuardian status through the status subresource
+// errors are logged and not returned, the next reconcile will retry the update
+func (r *AWSSecretGuardianReconciler) UpdateGuardianStatus(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) {
+	if err := r.Status().Update(ctx, awsSecretGuardian); err != nil {
+		logger.Info(fmt.Sprintf("Error updating the status of AWSSecretGuardian %s/%s: %s", awsSecretGuardian.Namespace, awsSecretGuardian.Name, err))
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+)
+
+// ErrInvalidTarget is returned when the k8s secret of a guardian can not be named or labeled as specified
+var ErrInvalidTarget = errors.New("invalid target")
+
+// ErrTargetNamespaceForbidden is returned when a guardian writes its secret outside of its namespace
+var ErrTargetNamespaceForbidden = errors.New("target.namespace must be the namespace of the AWSSecretGuardian")
+
+// K8STarget is the k8s secret written by a guardian, with the defaults of spec.target filled in
+type K8STarget struct {
+	Namespace   string
+	Name        string
+	Type        corev1.SecretType
+	Labels      map[string]string
+	Annotations map[string]string // user annotations, the controller annotations are added when the secret is written
+
+	DockerConfig *secretguardianv1alpha1.DockerConfigSpec // registry of a kubernetes.io/dockerconfigjson secret
+
+	Owner     *metav1.OwnerReference // controller reference to the guardian, nil if the secret outlives the guardian
+	OwnerName string                 // <namespace>/<name> of the guardian, written to OwnerAnnotation
+}
+
+// dockerConfigJSON is the content of the .dockerconfigjson key of a kubernetes.io/dockerconfigjson secret
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"` // base64 of username:password
+}
+
+// function to resolve the k8s secret of the guardian
+// the secret is named after the store secret, see SecretName, in the namespace of the guardian unless spec.target says otherwise
+// the controller can write Secrets in every namespace, so other namespaces are only allowed with AllowCrossNamespaceTargets,
+// otherwise anyone allowed to create a guardian could overwrite any Secret in the cluster
+// return the target, or an error wrapping ErrInvalidTarget or ErrTargetNamespaceForbidden
+func (r *AWSSecretGuardianReconciler) TargetFor(nameSpaceName string, spec *secretguardianv1alpha1.AWSSecretGuardianSpec) (*K8STarget, error) {
+	target := &K8STarget{Namespace: nameSpaceName, Name: spec.SecretName(), Type: K8SSecretType(spec.Keys)}
+	if spec.Target != nil {
+		if spec.Target.Name != "" {
+			target.Name = spec.Target.Name
+		}
+		if spec.Target.Namespace != "" {
+			target.Namespace = spec.Target.Namespace
+		}
+		if spec.Target.Type != "" {
+			target.Type = spec.Target.Type
+		}
+		target.Labels, target.Annotations = spec.Target.Labels, spec.Target.Annotations
+		if spec.Target.DockerConfig != nil {
+			dockerConfig := spec.Target.DockerConfig.DeepCopy()
+			if dockerConfig.UsernameKey == "" {
+				dockerConfig.UsernameKey = corev1.BasicAuthUsernameKey
+			}
+			if dockerConfig.PasswordKey == "" {
+				dockerConfig.PasswordKey = corev1.BasicAuthPasswordKey
+			}
+			target.DockerConfig = dockerConfig
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(target.Name); len(errs) > 0 {
+		return nil, fmt.Errorf("%w: secret name %q: %s, set target.name", ErrInvalidTarget, target.Name, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Label(target.Namespace); len(errs) > 0 {
+		return nil, fmt.Errorf("%w: namespace %q: %s", ErrInvalidTarget, target.Namespace, strings.Join(errs, ", "))
+	}
+	if target.Namespace != nameSpaceName && !r.AllowCrossNamespaceTargets {
+		return nil, fmt.Errorf("%w: %s/%s", ErrTargetNamespaceForbidden, target.Namespace, target.Name)
+	}
+	for name, value := range target.Labels {
+		errs := append(validation.IsQualifiedName(name), validation.IsValidLabelValue(value)...)
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%w: label %q: %s", ErrInvalidTarget, name, strings.Join(errs, ", "))
+		}
+	}
+	for name := range target.Annotations {
+		if errs := validation.IsQualifiedName(strings.ToLower(name)); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: annotation %q: %s", ErrInvalidTarget, name, strings.Join(errs, ", "))
+		}
+	}
+	if err := ValidateTargetKeys(target, spec); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// function to get the owner reference of the target secret to the guardian
+// the secret is garbage collected with the guardian unless its deletion policy is Orphan,
+// owner references can not cross namespaces, so a secret in another namespace is never owned
+// return the controller reference, or nil if the secret is not owned by the guardian
+func OwnerReferenceFor(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian, target *K8STarget) *metav1.OwnerReference {
+	if target.Namespace != awsSecretGuardian.Namespace {
+		return nil
+	}
+	if spec := awsSecretGuardian.Spec.Target; spec != nil && spec.DeletionPolicy == secretguardianv1alpha1.TargetDeletionPolicyOrphan {
+		return nil
+	}
+	return metav1.NewControllerRef(awsSecretGuardian, secretguardianv1alpha1.GroupVersion.WithKind("AWSSecretGuardian"))
+}
+
+// function to check that the guardian writes the keys required by the type of the secret
+// the API server refuses typed secrets without their keys, so the spec is refused before anything is written
+// return an error wrapping ErrInvalidTarget if a key is missing
+func ValidateTargetKeys(target *K8STarget, spec *secretguardianv1alpha1.AWSSecretGuardianSpec) error {
+	names := ManagedKeys(spec)
+	var required []string
+	switch target.Type {
+	case corev1.SecretTypeBasicAuth:
+		if !names[corev1.BasicAuthUsernameKey] && !names[corev1.BasicAuthPasswordKey] {
+			return fmt.Errorf("%w: a %s secret needs a %s or %s key", ErrInvalidTarget, target.Type, corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+		}
+	case corev1.SecretTypeSSHAuth:
+		required = []string{corev1.SSHAuthPrivateKey}
+	case corev1.SecretTypeTLS:
+		required = []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}
+	case corev1.SecretTypeDockerConfigJson:
+		if target.DockerConfig == nil {
+			return fmt.Errorf("%w: a %s secret needs target.dockerConfig", ErrInvalidTarget, target.Type)
+		}
+		if names[corev1.DockerConfigJsonKey] {
+			return fmt.Errorf("%w: %s is rendered from target.dockerConfig and can not be a key of the secret", ErrInvalidTarget, corev1.DockerConfigJsonKey)
+		}
+		required = []string{target.DockerConfig.UsernameKey, target.DockerConfig.PasswordKey}
+	}
+	for _, name := range required {
+		if !names[name] {
+			return fmt.Errorf("%w: a %s secret needs a %s key", ErrInvalidTarget, target.Type, name)
+		}
+	}
+	return nil
+}
+
+// function to get the data written to the target secret
+// a kubernetes.io/dockerconfigjson secret gets a .dockerconfigjson key rendered from its username and password keys
+// return the data of the secret, the data is not modified
+func TargetData(target *K8STarget, data map[string][]byte) (map[string][]byte, error) {
+	if target.Type != corev1.SecretTypeDockerConfigJson || target.DockerConfig == nil {
+		return data, nil
+	}
+	dockerConfig := target.DockerConfig
+	username, password := string(data[dockerConfig.UsernameKey]), string(data[dockerConfig.PasswordKey])
+	config, err := json.Marshal(dockerConfigJSON{Auths: map[string]dockerConfigEntry{
+		dockerConfig.Server: {
+			Username: username,
+			Password: password,
+			Email:    dockerConfig.Email,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	targetData := make(map[string][]byte, len(data)+1)
+	for name, value := range data {
+		targetData[name] = value
+	}
+	targetData[corev1.DockerConfigJsonKey] = config
+	return targetData, nil
+}
+
+// function to get the keys of the k8s secret written by the guardian
+// the keys of spec.keys with the extra keys of their generators and hashes, and the keys of spec.template
+// return the names of the keys
+func ManagedKeys(spec *secretguardianv1alpha1.AWSSecretGuardianSpec) map[string]bool {
+	names := map[string]bool{}
+	for i := range spec.Keys {
+		for _, name := range spec.Keys[i].SecretKeys() {
+			names[name] = true
+		}
+	}
+	if spec.Template != nil {
+		for name := range spec.Template.Data {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// function to apply the secret again with the current value of the store, without rotating it
+// brings the labels, annotations and keys rendered for the type of the secret up to date with the target,
+// and restores the keys changed or deleted outside of the controller, an apply that changes nothing does not change the secret
+// return an error if the secret can not be applied
+func (r *AWSSecretGuardianReconciler) SyncK8SSecret(ctx context.Context, target *K8STarget, current map[string]string, versionID string, rotationTime time.Time) error {
+	data, err := TargetData(target, K8SSecretData(current)) // e.g. a new registry server
+	if err != nil {
+		return err
+	}
+	_, err = r.CreateUpdateK8SSecret(ctx, target, data, versionID, rotationTime)
+	return err
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+func TestTargetFor(t *testing.T) {
+	r := &AWSSecretGuardianReconciler{}
+	spec := &secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db-password"}
+	target, err := r.TargetFor("default", spec)
+	if err != nil || target.Namespace != "default" || target.Name != "db-password" || target.Type != corev1.SecretTypeOpaque {
+		t.Fatalf("default target = %+v, %v", target, err)
+	}
+	spec = &secretguardianv1alpha1.AWSSecretGuardianSpec{AWS: &secretguardianv1alpha1.AWSSpec{SecretName: "payments-db"}}
+	target, err = r.TargetFor("default", spec)
+	if err != nil || target.Name != "payments-db" {
+		t.Fatalf("target of aws.secretName = %+v, %v", target, err)
+	}
+
+	cases := map[string]struct {
+		spec secretguardianv1alpha1.AWSSecretGuardianSpec
+		want error
+	}{
+		"path-style name":           {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "prod/payments/db"}, ErrInvalidTarget},
+		"path-style aws.secretName": {secretguardianv1alpha1.AWSSecretGuardianSpec{AWS: &secretguardianv1alpha1.AWSSpec{SecretName: "prod/payments/db"}}, ErrInvalidTarget},
+		"upper case name":           {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Target: &secretguardianv1alpha1.SecretTarget{Name: "DB"}}, ErrInvalidTarget},
+		"invalid label":             {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Target: &secretguardianv1alpha1.SecretTarget{Labels: map[string]string{"app": "a b"}}}, ErrInvalidTarget},
+		"other namespace":           {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Target: &secretguardianv1alpha1.SecretTarget{Namespace: "payments"}}, ErrTargetNamespaceForbidden},
+		"basic-auth without credentials": {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Keys: []secretguardianv1alpha1.KeySpec{{Name: "token"}},
+			Target: &secretguardianv1alpha1.SecretTarget{Type: corev1.SecretTypeBasicAuth}}, ErrInvalidTarget},
+		"ssh-auth without private key": {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Keys: []secretguardianv1alpha1.KeySpec{{Name: "password"}},
+			Target: &secretguardianv1alpha1.SecretTarget{Type: corev1.SecretTypeSSHAuth}}, ErrInvalidTarget},
+		"dockerconfigjson without password": {secretguardianv1alpha1.AWSSecretGuardianSpec{Name: "db", Keys: []secretguardianv1alpha1.KeySpec{{Name: "username"}},
+			Target: &secretguardianv1alpha1.SecretTarget{Type: corev1.SecretTypeDockerConfigJson, DockerConfig: &secretguardianv1alpha1.DockerConfigSpec{Server: "registry.example.com"}}}, ErrInvalidTarget},
+	}
+	for name, c := range cases {
+		if _, err := r.TargetFor("default", &c.spec); !errors.Is(err, c.want) {
+			t.Errorf("%s: %v, want %v", name, err, c.want)
+		}
+	}
+
+	r.AllowCrossNamespaceTargets = true
+	other := cases["other namespace"].spec
+	target, err = r.TargetFor("default", &other)
+	if err != nil || target.Namespace != "payments" {
+		t.Errorf("cross namespace target allowed by the controller = %+v, %v", target, err)
+	}
+}
+
+func TestReconcileWritesTarget(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Name = "" // a path-style store name
+	guardian.Spec.AWS = &secretguardianv1alpha1.AWSSpec{SecretName: "prod/payments/db"}
+	guardian.Spec.Target = &secretguardianv1alpha1.SecretTarget{
+		Name:        "payments-db",
+		Labels:      map[string]string{"app": "payments"},
+		Annotations: map[string]string{"team": "payments", RotationAnnotation: "ignored"},
+	}
+	r := newTestReconciler(t, store, guardian)
+
+	_, first, secret := reconcileGuardian(t, r)
+	if secret == nil || secret.Name != "payments-db" {
+		t.Fatalf("secret %v, want payments-db", secret)
+	}
+	if string(secret.Data["password"]) != store.Current("prod/payments/db")["password"] {
+		t.Error("k8s secret and store are out of sync")
+	}
+	if secret.Labels["app"] != "payments" || secret.Annotations["team"] != "payments" || secret.Annotations[RotationAnnotation] == "ignored" {
+		t.Errorf("labels %v annotations %v", secret.Labels, secret.Annotations)
+	}
+
+	ctx := context.Background() // a labels only change updates the secret without rotating it
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Target.Labels = map[string]string{"app": "payments", "tier": "db"}
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	if second.Status.AWSVersionID != first.Status.AWSVersionID || len(store.Secret("prod/payments/db").Versions) != 1 {
+		t.Error("changing the labels rotated the secret")
+	}
+	if secret.Labels["tier"] != "db" {
+		t.Errorf("labels %v, want the new label", secret.Labels)
+	}
+}
+
+func TestReconcileRefusesCrossNamespaceTarget(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Target = &secretguardianv1alpha1.SecretTarget{Namespace: "kube-system"}
+	r := newTestReconciler(t, store, guardian)
+
+	result, got, secret := reconcileGuardian(t, r)
+	if secret != nil || store.Secret("db-password") != nil {
+		t.Error("a secret was written to another namespace")
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionReady)
+	if condition == nil || condition.Reason != ReasonTargetNamespaceForbidden || result.RequeueAfter != 0 {
+		t.Errorf("Ready condition %v, requeue %s, want TargetNamespaceForbidden without requeue", condition, result.RequeueAfter)
+	}
+}
+
+func TestReconcileDockerConfigJSON(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Target = &secretguardianv1alpha1.SecretTarget{
+		Type:         corev1.SecretTypeDockerConfigJson,
+		DockerConfig: &secretguardianv1alpha1.DockerConfigSpec{Server: "registry.example.com"},
+	}
+	r := newTestReconciler(t, store, guardian)
+
+	_, first, secret := reconcileGuardian(t, r)
+	if secret == nil || secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Fatalf("secret %v, want a kubernetes.io/dockerconfigjson secret", secret)
+	}
+	var config dockerConfigJSON
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		t.Fatal(err)
+	}
+	value := store.Current("db-password")
+	auth := config.Auths["registry.example.com"]
+	if auth.Username != value["username"] || auth.Password != value["password"] ||
+		auth.Auth != base64.StdEncoding.EncodeToString([]byte(value["username"]+":"+value["password"])) {
+		t.Errorf("docker config %+v does not hold the credentials of the store", config)
+	}
+	if _, ok := value[corev1.DockerConfigJsonKey]; ok {
+		t.Error("the docker config was written to the store")
+	}
+
+	ctx := context.Background() // a new registry updates the secret without rotating it
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Target.DockerConfig.Server = "mirror.example.com"
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	if second.Status.AWSVersionID != first.Status.AWSVersionID {
+		t.Error("changing the registry rotated the secret")
+	}
+	config = dockerConfigJSON{}
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil || config.Auths["mirror.example.com"].Password != value["password"] {
+		t.Errorf("docker config %+v, %v, want the new registry", config, err)
+	}
+}
+
+func TestReconcileBasicAuth(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Target = &secretguardianv1alpha1.SecretTarget{Type: corev1.SecretTypeBasicAuth}
+	r := newTestReconciler(t, store, guardian)
+
+	_, _, secret := reconcileGuardian(t, r)
+	if secret == nil || secret.Type != corev1.SecretTypeBasicAuth || len(secret.Data[corev1.BasicAuthPasswordKey]) != 16 {
+		t.Errorf("secret %v, want a kubernetes.io/basic-auth secret with a password", secret)
+	}
+}
+
+func TestReconcileOwnsSecret(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	r := newTestReconciler(t, store, guardian)
+
+	_, _, secret := reconcileGuardian(t, r)
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != guardian.UID ||
+		secret.OwnerReferences[0].Controller == nil || !*secret.OwnerReferences[0].Controller {
+		t.Fatalf("owner references %v, want the guardian as controller", secret.OwnerReferences)
+	}
+
+	ctx := context.Background() // an orphaned secret drops its owner reference without rotating
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Target = &secretguardianv1alpha1.SecretTarget{DeletionPolicy: secretguardianv1alpha1.TargetDeletionPolicyOrphan}
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, _, secret = reconcileGuardian(t, r)
+	if len(secret.OwnerReferences) != 0 || len(store.Secret("db-password").Versions) != 1 {
+		t.Errorf("owner references %v after orphaning the secret", secret.OwnerReferences)
+	}
+
+	other := &K8STarget{Namespace: "payments", Name: "db-password"}
+	if owner := OwnerReferenceFor(newTestGuardian(), other); owner != nil {
+		t.Errorf("owner reference %v across namespaces", owner)
+	}
+}
+
+func TestReconcileRestoresTamperedSecret(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	r := newTestReconciler(t, store, guardian)
+	_, first, secret := reconcileGuardian(t, r)
+	value := store.Current("db-password")
+
+	ctx := context.Background()
+	secret.Data["password"] = []byte("tampered")
+	delete(secret.Data, "username")
+	if err := r.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	if string(secret.Data["password"]) != value["password"] || string(secret.Data["username"]) != value["username"] {
+		t.Errorf("secret data %v, want the current value of the store restored", secret.Data)
+	}
+	if len(store.Secret("db-password").Versions) != 1 || !second.Status.LastRotationTime.Equal(first.Status.LastRotationTime) {
+		t.Error("restoring the secret rotated it")
+	}
+}
+
+func TestReconcileRestoresDeletedSecret(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	r := newTestReconciler(t, store, guardian)
+	_, first, secret := reconcileGuardian(t, r)
+	value := store.Current("db-password")
+
+	if err := r.Delete(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	if secret == nil || string(secret.Data["password"]) != value["password"] || string(secret.Data["username"]) != value["username"] {
+		t.Fatalf("secret %v, want the current value of the store restored", secret)
+	}
+	if secret.Annotations[RotationAnnotation] != first.Status.LastRotationTime.UTC().Format(time.RFC3339) {
+		t.Errorf("rotation annotation %q, want the last rotation time kept", secret.Annotations[RotationAnnotation])
+	}
+	if len(store.Secret("db-password").Versions) != 1 || !second.Status.LastRotationTime.Equal(first.Status.LastRotationTime) {
+		t.Error("restoring the secret rotated it")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+)
+
+// ErrInvalidTemplate is returned when spec.template can not be parsed or executed
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// templateFuncs are the functions available to the templates besides the text/template builtins
+var templateFuncs = template.FuncMap{
+	"base64":      func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"pathescape":  url.PathEscape,
+	"queryescape": url.QueryEscape,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// function to render the templates of the spec over the values of the keys
+// the templates only see the values of the keys, not the other rendered keys, a missing value is an error
+// return the rendered values by key of the secret
+func RenderTemplate(spec *secretguardianv1alpha1.TemplateSpec, values map[string]string) (map[string]string, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	names := make([]string, 0, len(spec.Data))
+	for name := range spec.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names) // the first error is the same on every reconcile
+	rendered := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("%w: template %s: %s is already a key of the secret", ErrInvalidKeys, name, name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(spec.Data[name])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, values); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+		}
+		rendered[name] = b.String()
+	}
+	return rendered, nil
+}
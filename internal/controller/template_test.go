@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/fake"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[string]string{"username": "app", "password": "p@ss/word", "tls.crt": "CERT"}
+	spec := &secretguardianv1alpha1.TemplateSpec{Data: map[string]string{
+		"dsn":    "postgres://{{ .username }}:{{ .password | queryescape }}@db:5432/app",
+		"config": `{"user":{{ json .username }},"cert":{{ index . "tls.crt" | json }}}`,
+	}}
+	rendered, err := RenderTemplate(spec, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered["dsn"] != "postgres://app:p%40ss%2Fword@db:5432/app" {
+		t.Errorf("dsn = %q", rendered["dsn"])
+	}
+	if rendered["config"] != `{"user":"app","cert":"CERT"}` {
+		t.Errorf("config = %q", rendered["config"])
+	}
+
+	cases := map[string]error{
+		"{{ .missing }}": ErrInvalidTemplate,
+		"{{ .username":   ErrInvalidTemplate,
+	}
+	for text, want := range cases {
+		if _, err := RenderTemplate(&secretguardianv1alpha1.TemplateSpec{Data: map[string]string{"out": text}}, values); !errors.Is(err, want) {
+			t.Errorf("%q: %v, want %v", text, err, want)
+		}
+	}
+	if _, err := RenderTemplate(&secretguardianv1alpha1.TemplateSpec{Data: map[string]string{"password": "x"}}, values); !errors.Is(err, ErrInvalidKeys) {
+		t.Errorf("template overwriting a key: %v, want ErrInvalidKeys", err)
+	}
+}
+
+func TestReconcileRendersTemplate(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Template = &secretguardianv1alpha1.TemplateSpec{Data: map[string]string{
+		"dsn": "postgres://{{ .username }}:{{ .password }}@db:5432/app",
+	}}
+	r := newTestReconciler(t, store, guardian)
+
+	_, first, secret := reconcileGuardian(t, r)
+	value := store.Current("db-password")
+	want := "postgres://" + value["username"] + ":" + value["password"] + "@db:5432/app"
+	if value["dsn"] != want || string(secret.Data["dsn"]) != want {
+		t.Fatalf("dsn %q in the store and %q in k8s, want %q", value["dsn"], secret.Data["dsn"], want)
+	}
+
+	ctx := context.Background()
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Template.Data = map[string]string{"pgpass": "db:5432:app:{{ .username }}:{{ .password }}"}
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	refreshed := store.Current("db-password")
+	if refreshed["password"] != value["password"] || !second.Status.LastRotationTime.Equal(first.Status.LastRotationTime) {
+		t.Error("changing the template rotated the password")
+	}
+	if _, ok := secret.Data["dsn"]; ok || string(secret.Data["pgpass"]) != "db:5432:app:"+value["username"]+":"+value["password"] {
+		t.Errorf("secret keys %v, want the new template rendered and the old one removed", secret.Data)
+	}
+}
+
+func TestReconcileRemovesTemplate(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Template = &secretguardianv1alpha1.TemplateSpec{Data: map[string]string{
+		"dsn": "postgres://{{ .username }}:{{ .password }}@db:5432/app",
+	}}
+	r := newTestReconciler(t, store, guardian)
+	_, first, _ := reconcileGuardian(t, r)
+	value := store.Current("db-password")
+
+	ctx := context.Background()
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "guardian"}, guardian); err != nil {
+		t.Fatal(err)
+	}
+	guardian.Spec.Template = nil
+	if err := r.Update(ctx, guardian); err != nil {
+		t.Fatal(err)
+	}
+	_, second, secret := reconcileGuardian(t, r)
+	refreshed := store.Current("db-password")
+	if refreshed["password"] != value["password"] || !second.Status.LastRotationTime.Equal(first.Status.LastRotationTime) {
+		t.Error("removing the template rotated the password")
+	}
+	if _, ok := refreshed["dsn"]; ok {
+		t.Errorf("store keys %v, want the rendered key removed", refreshed)
+	}
+	if _, ok := secret.Data["dsn"]; ok {
+		t.Errorf("secret keys %v, want the rendered key removed", secret.Data)
+	}
+}
+
+func TestReconcileRefusesInvalidTemplate(t *testing.T) {
+	store := fake.New()
+	guardian := newTestGuardian()
+	guardian.Spec.Template = &secretguardianv1alpha1.TemplateSpec{Data: map[string]string{"dsn": "{{ .host }}"}}
+	r := newTestReconciler(t, store, guardian)
+
+	result, got, secret := reconcileGuardian(t, r)
+	if secret != nil || store.Secret("db-password") != nil {
+		t.Error("a secret was written with an invalid template")
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionReady)
+	if condition == nil || condition.Reason != ReasonInvalidTemplate || result.RequeueAfter != 0 {
+		t.Errorf("Ready condition %v, requeue %s, want InvalidTemplate without requeue", condition, result.RequeueAfter)
+	}
+}
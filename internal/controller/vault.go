@@ -0,0 +1,183 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/vaultstore"
+)
+
+// Defaults of the Vault provider
+const (
+	DefaultVaultMount               = "secret"
+	DefaultVaultKubernetesMountPath = "kubernetes"
+	DefaultVaultAppRoleMountPath    = "approle"
+	DefaultVaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// ErrVaultForbidden is returned when a guardian uses a Vault server or auth method the controller does not allow
+var ErrVaultForbidden = errors.New("vault server or auth method not allowed by the controller")
+
+// function to check that the controller allows the Vault server and the auth method of the guardian
+// the address must be one of VaultAddresses, no server is allowed if it is empty, the Kubernetes method sends the service account token of the controller
+// to the server, so it is only allowed with AllowVaultKubernetesAuth
+// return an error wrapping ErrVaultForbidden if the server or the method is not allowed
+func (r *AWSSecretGuardianReconciler) CheckVaultAllowed(vault *secretguardianv1alpha1.VaultSpec) error {
+	address := strings.TrimSuffix(vault.Address, "/")
+	allowed := false
+	for _, allowedAddress := range r.VaultAddresses {
+		if strings.TrimSuffix(allowedAddress, "/") == address {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: address %s is not one of the --vault-addresses of the controller", ErrVaultForbidden, vault.Address)
+	}
+	if vault.Auth.Method == secretguardianv1alpha1.VaultAuthKubernetes && !r.AllowVaultKubernetesAuth {
+		return fmt.Errorf("%w: the Kubernetes method logs in with the service account token of the controller "+
+			"and is only allowed when the controller runs with --allow-vault-kubernetes-auth", ErrVaultForbidden)
+	}
+	return nil
+}
+
+// function to get the Vault store of the AWSSecretGuardian
+// the controller logs in with the auth method of the guardian, tokens obtained with a login are cached
+// Secrets are always read from the namespace of the guardian
+// return the Vault store of the guardian, or an error wrapping ErrVaultForbidden
+func (r *AWSSecretGuardianReconciler) VaultStoreFor(ctx context.Context, awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) (*vaultstore.Store, error) {
+	vault := awsSecretGuardian.Spec.Vault
+	if vault == nil {
+		return nil, errors.New("spec.vault is required with the Vault provider")
+	}
+	if err := r.CheckVaultAllowed(vault); err != nil {
+		return nil, err
+	}
+	store := &vaultstore.Store{
+		Address:    vault.Address,
+		Namespace:  vault.Namespace,
+		Mount:      vault.Mount,
+		PathPrefix: vault.PathPrefix,
+	}
+	if store.Mount == "" {
+		store.Mount = DefaultVaultMount
+	}
+
+	key := client.ObjectKeyFromObject(awsSecretGuardian).String() // tokens are cached per guardian
+	auth := vault.Auth
+	switch auth.Method {
+	case secretguardianv1alpha1.VaultAuthToken:
+		if auth.TokenSecretRef == nil {
+			return nil, errors.New("spec.vault.auth.tokenSecretRef is required with the Token method")
+		}
+		token, _, err := r.GetSecretKey(ctx, awsSecretGuardian.Namespace, auth.TokenSecretRef)
+		if err != nil {
+			return nil, err
+		}
+		store.Token = token
+	case secretguardianv1alpha1.VaultAuthKubernetes:
+		if auth.Kubernetes == nil {
+			return nil, errors.New("spec.vault.auth.kubernetes is required with the Kubernetes method")
+		}
+		mountPath, tokenFile := auth.Kubernetes.MountPath, r.VaultKubernetesTokenFile // the token file is never taken from the spec
+		if mountPath == "" {
+			mountPath = DefaultVaultKubernetesMountPath
+		}
+		if tokenFile == "" {
+			tokenFile = DefaultVaultKubernetesTokenFile
+		}
+		fingerprint := fmt.Sprintf("kubernetes:%s:%s:%s:%s", vault.Address, mountPath, auth.Kubernetes.Role, tokenFile)
+		token, err := r.vaultTokens.GetOrLogin(key, fingerprint, func() (*vaultstore.Token, error) {
+			jwt, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, err
+			}
+			return vaultstore.Login(ctx, nil, vault.Address, vault.Namespace, mountPath, map[string]string{
+				"role": auth.Kubernetes.Role,
+				"jwt":  strings.TrimSpace(string(jwt)),
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault kubernetes login: %w", err)
+		}
+		store.Token = token.Token
+	case secretguardianv1alpha1.VaultAuthAppRole:
+		if auth.AppRole == nil {
+			return nil, errors.New("spec.vault.auth.appRole is required with the AppRole method")
+		}
+		mountPath := auth.AppRole.MountPath
+		if mountPath == "" {
+			mountPath = DefaultVaultAppRoleMountPath
+		}
+		secretID, resourceVersion, err := r.GetSecretKey(ctx, awsSecretGuardian.Namespace, &auth.AppRole.SecretIDRef)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint := fmt.Sprintf("approle:%s:%s:%s@%s", vault.Address, mountPath, auth.AppRole.RoleID, resourceVersion) // a new secret id logs in again
+		token, err := r.vaultTokens.GetOrLogin(key, fingerprint, func() (*vaultstore.Token, error) {
+			return vaultstore.Login(ctx, nil, vault.Address, vault.Namespace, mountPath, map[string]string{
+				"role_id":   auth.AppRole.RoleID,
+				"secret_id": secretID,
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault approle login: %w", err)
+		}
+		store.Token = token.Token
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method %q", auth.Method)
+	}
+	return store, nil
+}
+
+// function to get the Secret referenced by the Vault auth of the AWSSecretGuardian, if any
+// return nil if the auth method does not use a Secret
+func VaultSecretRefFor(awsSecretGuardian *secretguardianv1alpha1.AWSSecretGuardian) *secretguardianv1alpha1.SecretKeyReference {
+	vault := awsSecretGuardian.Spec.Vault
+	if awsSecretGuardian.Spec.Provider != secretguardianv1alpha1.ProviderVault || vault == nil {
+		return nil
+	}
+	switch {
+	case vault.Auth.Method == secretguardianv1alpha1.VaultAuthToken:
+		return vault.Auth.TokenSecretRef
+	case vault.Auth.Method == secretguardianv1alpha1.VaultAuthAppRole && vault.Auth.AppRole != nil:
+		return &vault.Auth.AppRole.SecretIDRef
+	}
+	return nil
+}
+
+// function to get the value of a key of a Secret in the namespace
+// return the value and the resource version of the Secret, an error if the key is empty
+func (r *AWSSecretGuardianReconciler) GetSecretKey(ctx context.Context, nameSpaceName string, ref *secretguardianv1alpha1.SecretKeyReference) (string, string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: nameSpaceName}, secret); err != nil {
+		return "", "", err
+	}
+	value := strings.TrimSpace(string(secret.Data[ref.Key]))
+	if value == "" {
+		return "", "", fmt.Errorf("%s is empty in Secret %s/%s", ref.Key, nameSpaceName, ref.Name)
+	}
+	return value, secret.ResourceVersion, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretguardianv1alpha1 "github.com/omerap12/K8s-Secret-Rotation-Controller/api/v1alpha1"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/vaultstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/vaultstore/vaulttest"
+)
+
+func TestReconcileVaultProvider(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AppRoles["guardian-role"] = "guardian-secret-id"
+
+	guardian := newTestGuardian()
+	guardian.Spec.Provider = secretguardianv1alpha1.ProviderVault
+	guardian.Spec.Vault = &secretguardianv1alpha1.VaultSpec{
+		Address:    server.URL,
+		PathPrefix: "team",
+		Auth: secretguardianv1alpha1.VaultAuthSpec{
+			Method: secretguardianv1alpha1.VaultAuthAppRole,
+			AppRole: &secretguardianv1alpha1.VaultAppRoleAuth{
+				RoleID:      "guardian-role",
+				SecretIDRef: secretguardianv1alpha1.SecretKeyReference{Name: "vault-approle", Key: "secret-id"},
+			},
+		},
+	}
+	approle := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-approle", Namespace: "default"},
+		Data:       map[string][]byte{"secret-id": []byte("guardian-secret-id")},
+	}
+	r := newTestReconciler(t, nil, guardian, approle)
+	r.NewSecretStore = nil // use the store of spec.provider
+	r.VaultAddresses = []string{server.URL}
+
+	_, got, secret := reconcileGuardian(t, r)
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, secretguardianv1alpha1.ConditionReady) {
+		t.Fatalf("Ready condition is not true: %v", got.Status.Conditions)
+	}
+	kv := server.Secret("team/db-password")
+	if kv == nil || len(kv.Versions) != 1 {
+		t.Fatalf("KV secret = %+v, want a single version at team/db-password", kv)
+	}
+	if kv.CustomMetadata[vaultstore.CurrentVersionKey] != got.Status.AWSVersionID || kv.CustomMetadata[vaultstore.PendingVersionKey] != "" {
+		t.Errorf("custom metadata = %v, status version %q", kv.CustomMetadata, got.Status.AWSVersionID)
+	}
+	if secret == nil || string(secret.Data["password"]) != kv.Versions[1]["password"] {
+		t.Error("k8s secret and Vault are out of sync")
+	}
+
+	requests := r.GuardiansForCredentialsSecret(context.Background(), approle)
+	if len(requests) != 1 || requests[0].Name != guardian.Name {
+		t.Errorf("AppRole Secret change enqueues %v, want the guardian", requests)
+	}
+}
+
+func TestCheckVaultAllowed(t *testing.T) {
+	const address = "https://vault.example.com:8200"
+	cases := map[string]struct {
+		addresses      []string
+		kubernetesAuth bool
+		vault          secretguardianv1alpha1.VaultSpec
+		wantForbidden  bool
+	}{
+		"any address without an allowlist": {
+			vault:         secretguardianv1alpha1.VaultSpec{Address: "https://attacker.example.com", Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthAppRole}},
+			wantForbidden: true,
+		},
+		"allowed address": {
+			addresses: []string{address + "/"},
+			vault:     secretguardianv1alpha1.VaultSpec{Address: address, Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthToken}},
+		},
+		"address not allowed": {
+			addresses:     []string{address},
+			vault:         secretguardianv1alpha1.VaultSpec{Address: "https://attacker.example.com", Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthToken}},
+			wantForbidden: true,
+		},
+		"kubernetes without the flag": {
+			addresses:     []string{address},
+			vault:         secretguardianv1alpha1.VaultSpec{Address: address, Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthKubernetes}},
+			wantForbidden: true,
+		},
+		"kubernetes without an allowlist": {
+			kubernetesAuth: true,
+			vault:          secretguardianv1alpha1.VaultSpec{Address: address, Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthKubernetes}},
+			wantForbidden:  true,
+		},
+		"kubernetes to an address not allowed": {
+			addresses:      []string{address},
+			kubernetesAuth: true,
+			vault:          secretguardianv1alpha1.VaultSpec{Address: "https://attacker.example.com", Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthKubernetes}},
+			wantForbidden:  true,
+		},
+		"kubernetes allowed": {
+			addresses:      []string{address},
+			kubernetesAuth: true,
+			vault:          secretguardianv1alpha1.VaultSpec{Address: address, Auth: secretguardianv1alpha1.VaultAuthSpec{Method: secretguardianv1alpha1.VaultAuthKubernetes}},
+		},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := &AWSSecretGuardianReconciler{VaultAddresses: tt.addresses, AllowVaultKubernetesAuth: tt.kubernetesAuth}
+			err := r.CheckVaultAllowed(&tt.vault)
+			if errors.Is(err, ErrVaultForbidden) != tt.wantForbidden {
+				t.Errorf("%v, want forbidden %t", err, tt.wantForbidden)
+			}
+		})
+	}
+}
+
+func TestReconcileVaultKubernetesAuth(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.KubernetesRoles["guardian"] = "controller-token"
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("controller-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	guardian := newTestGuardian()
+	guardian.Spec.Provider = secretguardianv1alpha1.ProviderVault
+	guardian.Spec.Vault = &secretguardianv1alpha1.VaultSpec{
+		Address: server.URL,
+		Auth: secretguardianv1alpha1.VaultAuthSpec{
+			Method:     secretguardianv1alpha1.VaultAuthKubernetes,
+			Kubernetes: &secretguardianv1alpha1.VaultKubernetesAuth{Role: "guardian"},
+		},
+	}
+	r := newTestReconciler(t, nil, guardian)
+	r.NewSecretStore = nil // use the store of spec.provider
+	r.VaultKubernetesTokenFile = tokenFile
+
+	result, got, secret := reconcileGuardian(t, r)
+	condition := meta.FindStatusCondition(got.Status.Conditions, secretguardianv1alpha1.ConditionAWSReachable)
+	if condition == nil || condition.Reason != ReasonVaultForbidden || result.RequeueAfter != 0 || secret != nil {
+		t.Fatalf("AWSReachable condition %v, requeue %s, want VaultForbidden without a requeue", condition, result.RequeueAfter)
+	}
+
+	r.VaultAddresses, r.AllowVaultKubernetesAuth = []string{server.URL}, true
+	_, got, secret = reconcileGuardian(t, r)
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, secretguardianv1alpha1.ConditionReady) || secret == nil {
+		t.Errorf("Ready condition is not true with the Kubernetes method allowed: %v", got.Status.Conditions)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+// DefaultCertificateDuration is the validity of a certificate without a duration, 90 days
+const DefaultCertificateDuration = 90 * 24 * time.Hour
+
+// CertificateRequest describes a certificate to issue
+type CertificateRequest struct {
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []net.IP
+	Duration    time.Duration // validity from now, DefaultCertificateDuration if zero
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	IsCA        bool
+}
+
+// Issuer is a CA that signs certificates
+type Issuer struct {
+	Certificate    *x509.Certificate
+	CertificatePEM string
+	PrivateKey     crypto.Signer
+}
+
+// Certificate is an issued certificate encoded for a secret
+type Certificate struct {
+	CertificatePEM string // "CERTIFICATE" PEM block
+	PrivateKeyPEM  string // PKCS#8 "PRIVATE KEY" PEM block
+	CAPEM          string // certificate of the issuer, empty for a self-signed certificate
+	NotAfter       time.Time
+}
+
+// function to parse the certificate and the private key of a CA, e.g. the tls.crt and tls.key of a Secret
+// return the issuer, or an error if the certificate is not a CA or does not match the key
+func ParseIssuer(certificatePEM string, privateKeyPEM string) (*Issuer, error) {
+	certificate, err := ParseCertificatePEM(certificatePEM)
+	if err != nil {
+		return nil, err
+	}
+	if !certificate.BasicConstraintsValid || !certificate.IsCA {
+		return nil, errors.New("the issuer certificate is not a CA")
+	}
+	privateKey, err := ParsePrivateKeyPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(publicDER, certificate.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("the issuer private key does not match its certificate")
+	}
+	return &Issuer{Certificate: certificate, CertificatePEM: certificatePEM, PrivateKey: privateKey}, nil
+}
+
+// function to parse the first certificate of a PEM
+// return the certificate
+func ParseCertificatePEM(certificatePEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no CERTIFICATE PEM block found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// function to issue a certificate for a new key pair drawn from Reader
+// the algorithm, bits and curve are the ones of GenerateKeyPair
+// the certificate is self-signed when issuer is nil, it never outlives its issuer
+// return the certificate and its private key encoded as PEM
+func GenerateCertificate(request CertificateRequest, algorithm string, bits int, curve string, issuer *Issuer) (*Certificate, error) {
+	keyPair, err := GenerateKeyPair(algorithm, bits, curve)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	duration := request.Duration
+	if duration == 0 {
+		duration = DefaultCertificateDuration
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: request.CommonName},
+		DNSNames:              request.DNSNames,
+		IPAddresses:           request.IPAddresses,
+		NotBefore:             now.Add(-time.Minute), // tolerate a small clock skew of the clients
+		NotAfter:              now.Add(duration),
+		KeyUsage:              request.KeyUsage,
+		ExtKeyUsage:           request.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  request.IsCA,
+	}
+	parent, signer := template, keyPair.PrivateKey
+	if issuer != nil {
+		parent, signer = issuer.Certificate, issuer.PrivateKey
+		if template.NotAfter.After(issuer.Certificate.NotAfter) {
+			template.NotAfter = issuer.Certificate.NotAfter
+		}
+	}
+	der, err := x509.CreateCertificate(Reader, template, parent, keyPair.PrivateKey.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	certificate := &Certificate{
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  keyPair.PrivateKeyPEM,
+		NotAfter:       template.NotAfter,
+	}
+	if issuer != nil {
+		certificate.CAPEM = issuer.CertificatePEM
+	}
+	return certificate, nil
+}
+
+// function to draw a positive serial number of 128 bits, RFC 5280 allows up to 20 octets
+func randomSerialNumber() (*big.Int, error) {
+	for {
+		b, err := Bytes(16)
+		if err != nil {
+			return nil, err
+		}
+		if serialNumber := new(big.Int).SetBytes(b); serialNumber.Sign() > 0 {
+			return serialNumber, nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	request := CertificateRequest{
+		CommonName:  "example.com",
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		Duration:    24 * time.Hour,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certificate, err := GenerateCertificate(request, ECDSA, 0, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCertificatePEM(certificate.CertificatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Subject.CommonName != "example.com" || len(parsed.DNSNames) != 2 || !parsed.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("subject %s, SANs %v %v", parsed.Subject, parsed.DNSNames, parsed.IPAddresses)
+	}
+	if !parsed.NotAfter.Equal(certificate.NotAfter.Truncate(time.Second)) || time.Until(parsed.NotAfter) > 24*time.Hour {
+		t.Errorf("NotAfter %s, want in 24 hours", parsed.NotAfter)
+	}
+	if err := parsed.VerifyHostname("www.example.com"); err != nil {
+		t.Error(err)
+	}
+	if err := parsed.CheckSignature(parsed.SignatureAlgorithm, parsed.RawTBSCertificate, parsed.Signature); err != nil {
+		t.Errorf("certificate is not self-signed: %v", err)
+	}
+	if certificate.CAPEM != "" {
+		t.Error("self-signed certificate has a CA")
+	}
+	if _, err := ParseIssuer(certificate.CertificatePEM, certificate.PrivateKeyPEM); err == nil {
+		t.Error("certificate without isCA accepted as an issuer")
+	}
+}
+
+func TestGenerateCertificateFromIssuer(t *testing.T) {
+	ca, err := GenerateCertificate(CertificateRequest{CommonName: "ca", Duration: time.Hour, KeyUsage: x509.KeyUsageCertSign, IsCA: true}, RSA, 0, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := ParseIssuer(ca.CertificatePEM, ca.PrivateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := CertificateRequest{DNSNames: []string{"svc.local"}, Duration: 48 * time.Hour, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	certificate, err := GenerateCertificate(request, Ed25519, 0, "", issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.CAPEM != ca.CertificatePEM {
+		t.Error("CA certificate not returned")
+	}
+	if !certificate.NotAfter.Equal(issuer.Certificate.NotAfter) {
+		t.Errorf("NotAfter %s outlives the issuer %s", certificate.NotAfter, issuer.Certificate.NotAfter)
+	}
+	parsed, err := ParseCertificatePEM(certificate.CertificatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer.Certificate)
+	if _, err := parsed.Verify(x509.VerifyOptions{DNSName: "svc.local", Roots: roots}); err != nil {
+		t.Errorf("certificate does not verify against its issuer: %v", err)
+	}
+
+	other, err := GenerateKeyPair(RSA, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseIssuer(ca.CertificatePEM, other.PrivateKeyPEM); err == nil {
+		t.Error("issuer with a mismatched private key accepted")
+	}
+}
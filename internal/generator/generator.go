@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package generator generates the secret values written by the controller
+// every value is drawn from crypto/rand
+package generator
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// DefaultCharset is the mix of uppercase, lowercase, numbers and special characters used for passwords
+const DefaultCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?~"
+
+// Reader is the source of randomness of the generators
+var Reader io.Reader = rand.Reader
+
+// function to generate a random password of length n from the charset
+// every character of the charset has the same probability at every position
+// return the password as a string
+func Password(length int, charset string) (string, error) {
+	if len(charset) == 0 {
+		return "", errors.New("empty charset")
+	}
+	password := make([]byte, length)
+	for i := range password {
+		index, err := RandomIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		password[i] = charset[index]
+	}
+	return string(password), nil
+}
+
+// function to draw a uniform random number in [0, n) from Reader
+// bytes that would make the low numbers more likely than the high ones are rejected,
+// so the result is unbiased for any n, unlike a plain modulo
+// return the random number
+func RandomIndex(n int) (int, error) {
+	if n <= 0 || n > 1<<32 {
+		return 0, errors.New("n out of range")
+	}
+	// draw as few bytes as needed to cover n values
+	size := 1
+	for max := uint64(256); max < uint64(n); max <<= 8 {
+		size++
+	}
+	limit := uint64(1) << (8 * size)
+	limit -= limit % uint64(n) // largest multiple of n that fits in size bytes
+	buf := make([]byte, size)
+	for {
+		if _, err := io.ReadFull(Reader, buf); err != nil {
+			return 0, err
+		}
+		var value uint64
+		for _, b := range buf {
+			value = value<<8 | uint64(b)
+		}
+		if value < limit {
+			return int(value % uint64(n)), nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPasswordUsesCharset(t *testing.T) {
+	password, err := Password(64, "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) != 64 || strings.Trim(password, "ab") != "" {
+		t.Errorf("password %q is not 64 characters of the charset", password)
+	}
+	if _, err := Password(8, ""); err == nil {
+		t.Error("empty charset accepted")
+	}
+}
+
+// the chi-square statistic of the character counts must stay below the 99.9% quantile of its distribution
+func TestPasswordUniformDistribution(t *testing.T) {
+	const samples = 200000
+	charset := DefaultCharset
+	password, err := Password(samples, charset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[byte]int, len(charset))
+	for i := 0; i < len(password); i++ {
+		counts[password[i]]++
+	}
+	if len(counts) != len(charset) {
+		t.Fatalf("%d distinct characters drawn, want %d", len(counts), len(charset))
+	}
+	expected := float64(samples) / float64(len(charset))
+	chiSquare := 0.0
+	for _, count := range counts {
+		diff := float64(count) - expected
+		chiSquare += diff * diff / expected
+	}
+	// 99.9% quantile of the chi-square distribution with len(DefaultCharset)-1 = 88 degrees of freedom is ~134.8
+	if chiSquare > 134.8 {
+		t.Errorf("chi-square %.1f, the characters are not uniformly distributed", chiSquare)
+	}
+}
+
+// with a modulo, a reader returning only 0xff would map to 255 % 90 = 75 every time
+func TestRandomIndexRejectsBiasedBytes(t *testing.T) {
+	reader := Reader
+	defer func() { Reader = reader }()
+	Reader = bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x05})
+	index, err := RandomIndex(90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 5 {
+		t.Errorf("RandomIndex = %d, want the bytes above the last multiple of 90 to be rejected", index)
+	}
+}
+
+func TestRandomIndexRange(t *testing.T) {
+	for _, n := range []int{1, 2, 255, 256, 257, 1 << 20} {
+		for i := 0; i < 100; i++ {
+			index, err := RandomIndex(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index < 0 || index >= n {
+				t.Fatalf("RandomIndex(%d) = %d", n, index)
+			}
+		}
+	}
+}
+
+// every run of the controller must produce a different sequence, even without any seeding
+// the test binary is started twice to generate passwords in two fresh processes
+func TestPasswordSequenceDiffersAcrossRestarts(t *testing.T) {
+	if os.Getenv("GENERATOR_PRINT_SEQUENCE") == "1" {
+		for i := 0; i < 4; i++ {
+			password, err := Password(32, DefaultCharset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Println(password)
+		}
+		return
+	}
+	sequence := func() string {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestPasswordSequenceDiffersAcrossRestarts$")
+		cmd.Env = append(os.Environ(), "GENERATOR_PRINT_SEQUENCE=1")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("running the test binary: %v", err)
+		}
+		return string(output)
+	}
+	first, second := sequence(), sequence()
+	if first == second {
+		t.Errorf("two processes produced the same sequence:\n%s", first)
+	}
+}
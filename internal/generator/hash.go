@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters of the hashes, Argon2id follows the second recommended option of RFC 9106
+const (
+	DefaultBCryptCost = bcrypt.DefaultCost
+	Argon2idTime      = 3
+	Argon2idMemory    = 64 * 1024 // KiB
+	Argon2idThreads   = 4
+	Argon2idKeyLength = 32
+	Argon2idSaltSize  = 16
+)
+
+// function to hash a value with bcrypt
+// return the modular crypt format hash, e.g. $2a$10$...
+func BCrypt(value string, cost int) (string, error) {
+	if cost == 0 {
+		cost = DefaultBCryptCost
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(value), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// function to hash a value with Argon2id and a random salt drawn from Reader
+// return the PHC string format hash, e.g. $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func Argon2id(value string) (string, error) {
+	salt, err := Bytes(Argon2idSaltSize)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(value), salt, Argon2idTime, Argon2idMemory, Argon2idThreads, Argon2idKeyLength)
+	encode := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, Argon2idMemory, Argon2idTime, Argon2idThreads, encode(salt), encode(key)), nil
+}
+
+// function to hash a value with SHA-256
+// return the hash as lowercase hex
+func SHA256(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrInvalidUsername is returned when a username can not be written to an htpasswd line
+var ErrInvalidUsername = errors.New("invalid htpasswd username")
+
+// function to build an htpasswd line for a user with a bcrypt hash of the password
+// the $2y$ prefix of Apache htpasswd is used, it is the same algorithm as $2a$
+// return the line, e.g. user:$2y$10$...
+func Htpasswd(username string, password string, cost int) (string, error) {
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return "", fmt.Errorf("%w %q", ErrInvalidUsername, username)
+	}
+	hash, err := BCrypt(password, cost)
+	if err != nil {
+		return "", err
+	}
+	return username + ":$2y$" + strings.TrimPrefix(hash, "$2a$"), nil
+}
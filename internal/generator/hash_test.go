@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBCrypt(t *testing.T) {
+	hash, err := BCrypt("secret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("secret")); err != nil {
+		t.Error(err)
+	}
+	if _, err := BCrypt(strings.Repeat("a", 73), bcrypt.MinCost); err == nil {
+		t.Error("value longer than 72 bytes accepted")
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	hash, err := Argon2id("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash, "$") // "", argon2id, v=19, m=65536,t=3,p=4, salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != "v=19" || parts[3] != "m=65536,t=3,p=4" {
+		t.Fatalf("hash %q is not in the PHC string format", hash)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("secret"), salt, Argon2idTime, Argon2idMemory, Argon2idThreads, Argon2idKeyLength))
+	if key != parts[5] {
+		t.Error("hash does not verify")
+	}
+	again, err := Argon2id("secret")
+	if err != nil || again == hash {
+		t.Errorf("two hashes of the same value are equal, the salt is not random: %v", err)
+	}
+}
+
+func TestSHA256(t *testing.T) {
+	if got := SHA256("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("SHA256(abc) = %s", got)
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	line, err := Htpasswd("admin", "secret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, hash, _ := strings.Cut(line, ":")
+	if username != "admin" || !strings.HasPrefix(hash, "$2y$") {
+		t.Fatalf("line %q", line)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("secret")); err != nil {
+		t.Error(err)
+	}
+	if _, err := Htpasswd("ad:min", "secret", bcrypt.MinCost); !errors.Is(err, ErrInvalidUsername) {
+		t.Error("username with a colon accepted")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Key pair algorithms
+const (
+	RSA     = "RSA"
+	ECDSA   = "ECDSA"
+	Ed25519 = "Ed25519"
+)
+
+// Defaults of the key pair generator
+const (
+	DefaultRSABits    = 2048
+	DefaultECDSACurve = "P-256"
+)
+
+// KeyPair is a generated key pair encoded for a secret
+type KeyPair struct {
+	PrivateKey    crypto.Signer
+	PrivateKeyPEM string // PKCS#8 "PRIVATE KEY" PEM block
+	PublicKeyPEM  string // PKIX "PUBLIC KEY" PEM block
+}
+
+// function to generate a key pair drawn from Reader
+// bits is the RSA modulus size and curve the ECDSA curve, P-256, P-384 or P-521, zero values use the defaults
+// return the key pair encoded as PEM
+func GenerateKeyPair(algorithm string, bits int, curve string) (*KeyPair, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch algorithm {
+	case RSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits, got %d", bits)
+		}
+		privateKey, err = rsa.GenerateKey(Reader, bits)
+	case ECDSA:
+		if curve == "" {
+			curve = DefaultECDSACurve
+		}
+		ellipticCurve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[curve]
+		if !ok {
+			return nil, fmt.Errorf("unknown ECDSA curve %q", curve)
+		}
+		privateKey, err = ecdsa.GenerateKey(ellipticCurve, Reader)
+	case Ed25519:
+		_, privateKey, err = ed25519.GenerateKey(Reader)
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return EncodeKeyPair(privateKey)
+}
+
+// function to encode a private key and its public key as PEM
+// return the key pair
+func EncodeKeyPair(privateKey crypto.Signer) (*KeyPair, error) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		PrivateKey:    privateKey,
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+		PublicKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}, nil
+}
+
+// function to parse a private key PEM, e.g. the current value of a key pair or the key of a CA
+// PKCS#8 "PRIVATE KEY", PKCS#1 "RSA PRIVATE KEY" and SEC 1 "EC PRIVATE KEY" blocks are accepted
+// return the private key
+func ParsePrivateKeyPEM(privateKeyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// JWK is a public JSON Web Key, RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	Exp int64  `json:"exp,omitempty"` // unix time a previous key is removed from its JWKS, not set on the current key
+}
+
+// JWKS is a JSON Web Key Set, RFC 7517 section 5
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// function to encode a public key as a signing JWK
+// the key id is the RFC 7638 thumbprint of the key, so it does not change when the JWK is encoded again
+// return the JWK
+func PublicJWK(publicKey crypto.PublicKey) (*JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwk := &JWK{Use: "sig"}
+	var thumbprint interface{}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty, jwk.Alg = "RSA", "RS256"
+		jwk.N, jwk.E = encode(key.N.Bytes()), encode(big.NewInt(int64(key.E)).Bytes())
+		thumbprint = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", key.Curve.Params().Name
+		jwk.Alg = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[jwk.Crv]
+		jwk.X, jwk.Y = encode(key.X.FillBytes(make([]byte, size))), encode(key.Y.FillBytes(make([]byte, size)))
+		thumbprint = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv, jwk.Alg = "OKP", "Ed25519", "EdDSA"
+		jwk.X = encode(key)
+		thumbprint = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	canonical, err := json.Marshal(thumbprint) // members in lexicographic order without whitespace
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(canonical)
+	jwk.Kid = encode(sum[:])
+	return jwk, nil
+}
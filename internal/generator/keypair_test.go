@@ -0,0 +1,125 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	cases := []struct {
+		algorithm string
+		bits      int
+		curve     string
+		check     func(crypto.PublicKey) bool
+		alg       string
+	}{
+		{RSA, 0, "", func(k crypto.PublicKey) bool { key, ok := k.(*rsa.PublicKey); return ok && key.N.BitLen() == 2048 }, "RS256"},
+		{RSA, 3072, "", func(k crypto.PublicKey) bool { key, ok := k.(*rsa.PublicKey); return ok && key.N.BitLen() == 3072 }, "RS256"},
+		{ECDSA, 0, "", func(k crypto.PublicKey) bool {
+			key, ok := k.(*ecdsa.PublicKey)
+			return ok && key.Curve.Params().Name == "P-256"
+		}, "ES256"},
+		{ECDSA, 0, "P-384", func(k crypto.PublicKey) bool {
+			key, ok := k.(*ecdsa.PublicKey)
+			return ok && key.Curve.Params().Name == "P-384"
+		}, "ES384"},
+		{Ed25519, 0, "", func(k crypto.PublicKey) bool { _, ok := k.(ed25519.PublicKey); return ok }, "EdDSA"},
+	}
+	for _, c := range cases {
+		keyPair, err := GenerateKeyPair(c.algorithm, c.bits, c.curve)
+		if err != nil {
+			t.Fatalf("%s: %v", c.algorithm, err)
+		}
+		privateBlock, _ := pem.Decode([]byte(keyPair.PrivateKeyPEM))
+		if privateBlock == nil || privateBlock.Type != "PRIVATE KEY" {
+			t.Fatalf("%s: private key is not a PKCS#8 PEM", c.algorithm)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(privateBlock.Bytes); err != nil {
+			t.Fatalf("%s: %v", c.algorithm, err)
+		}
+		publicBlock, _ := pem.Decode([]byte(keyPair.PublicKeyPEM))
+		if publicBlock == nil || publicBlock.Type != "PUBLIC KEY" {
+			t.Fatalf("%s: public key is not a PKIX PEM", c.algorithm)
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(publicBlock.Bytes)
+		if err != nil || !c.check(publicKey) {
+			t.Fatalf("%s: unexpected public key %T, %v", c.algorithm, publicKey, err)
+		}
+
+		jwk, err := PublicJWK(publicKey)
+		if err != nil || jwk.Alg != c.alg || jwk.Use != "sig" || jwk.Kid == "" {
+			t.Fatalf("%s: JWK %+v, %v", c.algorithm, jwk, err)
+		}
+		parsed, err := ParsePrivateKeyPEM(keyPair.PrivateKeyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := PublicJWK(parsed.Public())
+		if err != nil || *again != *jwk {
+			t.Errorf("%s: JWK of the parsed private key differs: %+v", c.algorithm, again)
+		}
+	}
+}
+
+// RFC 7638 section 3.1 example
+func TestPublicJWKThumbprint(t *testing.T) {
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	publicKey, err := jwkToRSA(n, "AQAB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := PublicJWK(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.Kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("kid = %s", jwk.Kid)
+	}
+}
+
+func TestGenerateKeyPairInvalid(t *testing.T) {
+	if _, err := GenerateKeyPair(RSA, 1024, ""); err == nil {
+		t.Error("1024 bit RSA key accepted")
+	}
+	if _, err := GenerateKeyPair(ECDSA, 0, "P-224"); err == nil {
+		t.Error("unknown curve accepted")
+	}
+	if _, err := GenerateKeyPair("DSA", 0, ""); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+func jwkToRSA(n string, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(new(big.Int).SetBytes(eBytes).Int64())}, nil
+}
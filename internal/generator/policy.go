@@ -0,0 +1,241 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Character classes of a password policy
+const (
+	Upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Lower  = "abcdefghijklmnopqrstuvwxyz"
+	Digits = "0123456789"
+	// DefaultSymbols are the symbols of DefaultCharset
+	DefaultSymbols = "!@#$%^&*()_+-=[]{}|;:,.<>?~"
+	// Ambiguous are the characters that look alike in many fonts
+	Ambiguous = "0Oo1lI|"
+)
+
+// ErrInvalidPolicy is returned when no password can satisfy a policy
+var ErrInvalidPolicy = errors.New("invalid password policy")
+
+// Policy constrains the characters of a generated password
+type Policy struct {
+	Upper, Lower, Digits, Symbols bool   // classes the password is drawn from
+	SymbolSet                     string // symbols used by the Symbols class, DefaultSymbols if empty
+
+	Exclude     string // characters never used
+	NoAmbiguous bool   // exclude the Ambiguous characters
+
+	MinUpper, MinLower, MinDigits, MinSymbols int // minimum number of characters of each class
+
+	NoRepeatedCharacters bool // no character directly follows itself, classes with a minimum need two characters or more
+	StartWithLetter      bool // the first character is an uppercase or lowercase letter
+}
+
+// class is the set of characters of one class after the exclusions, with its minimum count
+type class struct {
+	name  string
+	chars string
+	min   int
+}
+
+// function to generate a random password of length n satisfying the policy
+// the minimum counts are placed at random positions, the other positions are drawn from every allowed character
+// the password is built position by position, so the constraints hold without retrying
+// return the password, or ErrInvalidPolicy if the policy can not be satisfied
+func PolicyPassword(length int, policy Policy) (string, error) {
+	classes, err := policy.classes()
+	if err != nil {
+		return "", err
+	}
+	var all, letters string
+	required := 0
+	for _, c := range classes {
+		all += c.chars
+		if c.name == "upper" || c.name == "lower" {
+			letters += c.chars
+		}
+		required += c.min
+	}
+	all, letters = dedupe(all), dedupe(letters) // custom symbols may repeat letters or digits
+	if required > length {
+		return "", fmt.Errorf("%w: the minimum counts add up to %d, more than the length %d", ErrInvalidPolicy, required, length)
+	}
+	if policy.NoRepeatedCharacters && length > 1 {
+		// a position only loses the character before it, so every set drawn from after the first position
+		// needs a second character, the policy is refused up front instead of failing at random
+		for _, c := range classes {
+			if c.min > 0 && utf8.RuneCountInString(c.chars) < 2 {
+				return "", fmt.Errorf("%w: noRepeatedCharacters needs at least two characters of %s, %q is left", ErrInvalidPolicy, c.name, c.chars)
+			}
+		}
+		if utf8.RuneCountInString(all) < 2 {
+			return "", fmt.Errorf("%w: noRepeatedCharacters needs at least two characters, %q is left", ErrInvalidPolicy, all)
+		}
+	}
+	if policy.StartWithLetter && length > 0 {
+		if letters == "" {
+			return "", fmt.Errorf("%w: startWithLetter needs uppercase or lowercase letters", ErrInvalidPolicy)
+		}
+		if required-minOf(classes, "upper")-minOf(classes, "lower") > length-1 {
+			return "", fmt.Errorf("%w: the minimum counts leave no room for a leading letter", ErrInvalidPolicy)
+		}
+	}
+
+	// the set each position is drawn from, the minimum counts first then every allowed character
+	sets := make([]string, 0, length)
+	for _, c := range classes {
+		for i := 0; i < c.min; i++ {
+			sets = append(sets, c.chars)
+		}
+	}
+	for len(sets) < length {
+		sets = append(sets, all)
+	}
+	if err := shuffle(sets); err != nil {
+		return "", err
+	}
+	if policy.StartWithLetter && length > 0 && !isLetterSet(sets[0], letters) {
+		for i := 1; i < length; i++ { // move a letter class to the front, or replace a free position by a letter
+			if isLetterSet(sets[i], letters) {
+				sets[0], sets[i] = sets[i], sets[0]
+				break
+			}
+		}
+		if !isLetterSet(sets[0], letters) {
+			for i := 1; i < length; i++ {
+				if sets[i] == all {
+					sets[0], sets[i] = letters, sets[0]
+					break
+				}
+			}
+		}
+		if sets[0] == all {
+			sets[0] = letters
+		}
+	}
+
+	password := make([]rune, length) // symbols may be any UTF-8 character, the sets are drawn from by rune
+	for i, set := range sets {
+		if policy.NoRepeatedCharacters && i > 0 { // never empty, every set has two characters or more
+			set = strings.ReplaceAll(set, string(password[i-1]), "")
+		}
+		chars := []rune(set)
+		index, err := RandomIndex(len(chars))
+		if err != nil {
+			return "", err
+		}
+		password[i] = chars[index]
+	}
+	return string(password), nil
+}
+
+// function to build the character classes of the policy
+// return the classes with at least one character, or ErrInvalidPolicy
+func (p Policy) classes() ([]class, error) {
+	symbols := p.SymbolSet
+	if symbols == "" {
+		symbols = DefaultSymbols
+	}
+	candidates := []struct {
+		class
+		enabled bool
+	}{
+		{class{"upper", Upper, p.MinUpper}, p.Upper},
+		{class{"lower", Lower, p.MinLower}, p.Lower},
+		{class{"digits", Digits, p.MinDigits}, p.Digits},
+		{class{"symbols", symbols, p.MinSymbols}, p.Symbols},
+	}
+	var classes []class
+	for _, candidate := range candidates {
+		c := candidate.class
+		if c.min < 0 {
+			return nil, fmt.Errorf("%w: negative minimum for %s", ErrInvalidPolicy, c.name)
+		}
+		if !candidate.enabled {
+			if c.min > 0 {
+				return nil, fmt.Errorf("%w: a minimum is set for %s but the class is not included", ErrInvalidPolicy, c.name)
+			}
+			continue
+		}
+		c.chars = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(p.Exclude, r) || (p.NoAmbiguous && strings.ContainsRune(Ambiguous, r)) {
+				return -1
+			}
+			return r
+		}, c.chars)
+		c.chars = dedupe(c.chars)
+		if c.chars == "" {
+			if c.min > 0 {
+				return nil, fmt.Errorf("%w: every character of %s is excluded", ErrInvalidPolicy, c.name)
+			}
+			continue
+		}
+		classes = append(classes, c)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("%w: no characters left", ErrInvalidPolicy)
+	}
+	return classes, nil
+}
+
+// function to remove the duplicate characters of a set, so every character has the same probability
+func dedupe(chars string) string {
+	var b strings.Builder
+	for _, r := range chars {
+		if !strings.ContainsRune(b.String(), r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func minOf(classes []class, name string) int {
+	for _, c := range classes {
+		if c.name == name {
+			return c.min
+		}
+	}
+	return 0
+}
+
+// function to check if every character of the set is a letter
+func isLetterSet(set string, letters string) bool {
+	for _, r := range set {
+		if !strings.ContainsRune(letters, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// function to shuffle the sets with a Fisher-Yates shuffle drawn from Reader
+func shuffle(sets []string) error {
+	for i := len(sets) - 1; i > 0; i-- {
+		j, err := RandomIndex(i + 1)
+		if err != nil {
+			return err
+		}
+		sets[i], sets[j] = sets[j], sets[i]
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func countIn(password string, chars string) int {
+	count := 0
+	for _, r := range password {
+		if strings.ContainsRune(chars, r) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPolicyPasswordMinimumCounts(t *testing.T) {
+	policy := Policy{Upper: true, Lower: true, Digits: true, Symbols: true, SymbolSet: "-_", MinUpper: 3, MinLower: 2, MinDigits: 4, MinSymbols: 1}
+	for i := 0; i < 500; i++ {
+		password, err := PolicyPassword(10, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != 10 || countIn(password, Upper) < 3 || countIn(password, Lower) < 2 || countIn(password, Digits) < 4 || countIn(password, "-_") < 1 {
+			t.Fatalf("password %q does not meet the minimum counts", password)
+		}
+		if countIn(password, Upper+Lower+Digits+"-_") != 10 {
+			t.Fatalf("password %q has characters outside of the policy", password)
+		}
+	}
+}
+
+func TestPolicyPasswordExclusions(t *testing.T) {
+	policy := Policy{Upper: true, Lower: true, Digits: true, Symbols: true, Exclude: ";:,.<>?~", NoAmbiguous: true}
+	for i := 0; i < 200; i++ {
+		password, err := PolicyPassword(64, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(password, ";:,.<>?~"+Ambiguous) {
+			t.Fatalf("password %q has excluded characters", password)
+		}
+	}
+}
+
+func TestPolicyPasswordShape(t *testing.T) {
+	policy := Policy{Digits: true, Lower: true, MinDigits: 7, NoRepeatedCharacters: true, StartWithLetter: true}
+	for i := 0; i < 500; i++ {
+		password, err := PolicyPassword(8, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !unicode.IsLetter(rune(password[0])) {
+			t.Fatalf("password %q does not start with a letter", password)
+		}
+		for j := 1; j < len(password); j++ {
+			if password[j] == password[j-1] {
+				t.Fatalf("password %q repeats %q", password, password[j])
+			}
+		}
+	}
+}
+
+func TestPolicyPasswordInvalid(t *testing.T) {
+	policies := map[string]Policy{
+		"minimums above length":        {Upper: true, Digits: true, MinUpper: 5, MinDigits: 6},
+		"minimum of excluded class":    {Lower: true, MinDigits: 1},
+		"every character excluded":     {Digits: true, Exclude: Digits},
+		"no room for a leading letter": {Upper: true, Digits: true, MinDigits: 10, StartWithLetter: true},
+		"no letters to start with":     {Digits: true, StartWithLetter: true},
+		"single character minimum":     {Digits: true, Lower: true, Exclude: "012345678", MinDigits: 2, NoRepeatedCharacters: true},
+		"single character left":        {Digits: true, Exclude: "012345678", NoRepeatedCharacters: true},
+	}
+	for name, policy := range policies {
+		if _, err := PolicyPassword(10, policy); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: err = %v, want ErrInvalidPolicy", name, err)
+		}
+	}
+}
+
+func TestPolicyPasswordNoRepeatedCharactersNeverFails(t *testing.T) {
+	// two characters per class is the smallest policy that can always be generated
+	policy := Policy{Upper: true, Digits: true, Exclude: Upper[2:] + Digits[2:], MinUpper: 3, MinDigits: 3, NoRepeatedCharacters: true, StartWithLetter: true}
+	for i := 0; i < 2000; i++ {
+		password, err := PolicyPassword(8, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j < len(password); j++ {
+			if password[j] == password[j-1] {
+				t.Fatalf("password %q repeats %q", password, password[j])
+			}
+		}
+	}
+}
+
+func TestPolicyPasswordNonASCIISymbols(t *testing.T) {
+	policy := Policy{Lower: true, Symbols: true, SymbolSet: "€§é", MinSymbols: 2, NoRepeatedCharacters: true}
+	for i := 0; i < 500; i++ {
+		password, err := PolicyPassword(12, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.ValidString(password) || utf8.RuneCountInString(password) != 12 || countIn(password, "€§é") < 2 {
+			t.Fatalf("password %q is not 12 characters with 2 symbols", password)
+		}
+		if countIn(password, Lower+"€§é") != 12 {
+			t.Fatalf("password %q has characters outside of the policy", password)
+		}
+		runes := []rune(password)
+		for j := 1; j < len(runes); j++ {
+			if runes[j] == runes[j-1] {
+				t.Fatalf("password %q repeats %q", password, runes[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"encoding/pem"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHKeyPair is a generated key pair encoded for SSH
+type SSHKeyPair struct {
+	PrivateKey    string // "OPENSSH PRIVATE KEY" PEM block
+	AuthorizedKey string // public key as an authorized_keys line
+}
+
+// function to generate an SSH key pair drawn from Reader
+// the algorithm, bits and curve are the ones of GenerateKeyPair, the comment is added to both keys
+// return the OpenSSH private key and the authorized_keys line
+func GenerateSSHKeyPair(algorithm string, bits int, curve string, comment string) (*SSHKeyPair, error) {
+	keyPair, err := GenerateKeyPair(algorithm, bits, curve)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(keyPair.PrivateKey, comment)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(keyPair.PrivateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SSHKeyPair{
+		PrivateKey:    string(pem.EncodeToMemory(block)),
+		AuthorizedKey: authorizedKey(publicKey, comment),
+	}, nil
+}
+
+// function to get the authorized_keys line of an OpenSSH private key, e.g. the current value of an SSH key pair
+// return the authorized_keys line with the comment
+func SSHAuthorizedKey(privateKey string, comment string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", err
+	}
+	return authorizedKey(signer.PublicKey(), comment), nil
+}
+
+// function to format a public key as an authorized_keys line
+// return the line with the comment, if any
+func authorizedKey(publicKey ssh.PublicKey, comment string) string {
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(publicKey)), "\n")
+	if comment != "" {
+		line += " " + comment
+	}
+	return line + "\n"
+}
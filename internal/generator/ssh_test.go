@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateSSHKeyPair(t *testing.T) {
+	cases := map[string]string{RSA: ssh.KeyAlgoRSA, ECDSA: ssh.KeyAlgoECDSA256, Ed25519: ssh.KeyAlgoED25519}
+	for algorithm, keyType := range cases {
+		keyPair, err := GenerateSSHKeyPair(algorithm, 0, "", "deploy@example.com")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(keyPair.PrivateKey))
+		if err != nil {
+			t.Fatalf("%s: private key: %v", algorithm, err)
+		}
+		publicKey, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(keyPair.AuthorizedKey))
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("%s: authorized key %q: %v", algorithm, keyPair.AuthorizedKey, err)
+		}
+		if publicKey.Type() != keyType || comment != "deploy@example.com" {
+			t.Errorf("%s: type %s comment %q", algorithm, publicKey.Type(), comment)
+		}
+		if !bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			t.Errorf("%s: the authorized key does not match the private key", algorithm)
+		}
+	}
+}
+
+func TestGenerateSSHKeyPairWithoutComment(t *testing.T) {
+	keyPair, err := GenerateSSHKeyPair(ECDSA, 0, "P-384", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(keyPair.AuthorizedKey))
+	if err != nil || comment != "" || publicKey.Type() != ssh.KeyAlgoECDSA384 {
+		t.Errorf("authorized key %q: %v", keyPair.AuthorizedKey, err)
+	}
+}
+
+func TestSSHAuthorizedKey(t *testing.T) {
+	keyPair, err := GenerateSSHKeyPair(Ed25519, 0, "", "deploy@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey, err := SSHAuthorizedKey(keyPair.PrivateKey, "deploy@example.com")
+	if err != nil || authorizedKey != keyPair.AuthorizedKey {
+		t.Errorf("authorized key %q, %v, want %q", authorizedKey, err, keyPair.AuthorizedKey)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Words is the EFF short word list 2.0 used for passphrases, 1296 words with unique three letter prefixes
+// https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases, licensed under CC BY 3.0 US
+var Words = strings.Fields(wordList)
+
+//go:embed eff_short_wordlist.txt
+var wordList string
+
+// DefaultSeparator separates the words of a passphrase
+const DefaultSeparator = "-"
+
+// function to draw n random bytes from Reader
+// return the bytes
+func Bytes(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, errors.New("the number of bytes must be positive")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// function to generate a random UUID version 4
+// return the UUID in its canonical form, e.g. 0b6c5f0e-8d3a-4c8e-9a55-2f8f4d0f7c21
+func UUID() (string, error) {
+	b, err := Bytes(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// function to generate n random bytes encoded as lowercase hex
+// return a string of 2n characters
+func Hex(n int) (string, error) {
+	b, err := Bytes(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// function to generate n random bytes encoded as padded standard base64
+// return the encoded bytes
+func Base64(n int) (string, error) {
+	b, err := Bytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// function to generate n random bytes encoded as unpadded URL safe base64, for tokens used in URLs and headers
+// return the encoded bytes
+func Base64URL(n int) (string, error) {
+	b, err := Bytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// function to generate a diceware style passphrase of n words from Words
+// every word adds log2(1296), about 10.3 bits of entropy
+// return the words joined with the separator
+func Passphrase(n int, separator string) (string, error) {
+	if n <= 0 {
+		return "", errors.New("the number of words must be positive")
+	}
+	words := make([]string, n)
+	for i := range words {
+		index, err := RandomIndex(len(Words))
+		if err != nil {
+			return "", err
+		}
+		words[i] = Words[index]
+	}
+	return strings.Join(words, separator), nil
+}
+
+// function to generate a numeric PIN of n digits, leading zeros included
+// return the PIN
+func PIN(n int) (string, error) {
+	if n <= 0 {
+		return "", errors.New("the number of digits must be positive")
+	}
+	return Password(n, Digits)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// function to compute the Shannon entropy in bits per symbol of the symbols of the samples
+func entropy(samples []string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, sample := range samples {
+		for _, r := range sample {
+			counts[r]++
+			total++
+		}
+	}
+	bits := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// function to generate n samples and fail on duplicates
+func samples(t *testing.T, n int, generate func() (string, error)) []string {
+	t.Helper()
+	seen := make(map[string]bool, n)
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		value, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[value] {
+			t.Fatalf("duplicate value %q after %d samples", value, i)
+		}
+		seen[value] = true
+		out = append(out, value)
+	}
+	return out
+}
+
+func TestUUID(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, value := range samples(t, 2000, UUID) {
+		if !format.MatchString(value) {
+			t.Fatalf("%q is not a version 4 UUID", value)
+		}
+	}
+}
+
+func TestHex(t *testing.T) {
+	values := samples(t, 2000, func() (string, error) { return Hex(32) })
+	for _, value := range values {
+		if b, err := hex.DecodeString(value); err != nil || len(b) != 32 || value != strings.ToLower(value) {
+			t.Fatalf("%q is not 32 bytes of lowercase hex", value)
+		}
+	}
+	if bits := entropy(values); bits < 3.99 { // 4 bits per hex digit
+		t.Errorf("hex entropy %.3f bits per character, want 4", bits)
+	}
+}
+
+func TestBase64(t *testing.T) {
+	values := samples(t, 2000, func() (string, error) { return Base64(30) })
+	for _, value := range values {
+		if b, err := base64.StdEncoding.DecodeString(value); err != nil || len(b) != 30 {
+			t.Fatalf("%q is not 30 bytes of base64", value)
+		}
+	}
+	if bits := entropy(values); bits < 5.98 { // 6 bits per base64 character
+		t.Errorf("base64 entropy %.3f bits per character, want 6", bits)
+	}
+
+	for _, value := range samples(t, 2000, func() (string, error) { return Base64URL(31) }) {
+		if b, err := base64.RawURLEncoding.DecodeString(value); err != nil || len(b) != 31 || strings.ContainsAny(value, "+/=") {
+			t.Fatalf("%q is not 31 bytes of unpadded base64url", value)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	if len(Words) != 1296 { // 6^4, four dice per word
+		t.Fatalf("word list has %d words, want 1296", len(Words))
+	}
+	known := make(map[string]bool, len(Words))
+	for _, word := range Words {
+		known[word] = true
+	}
+	used := map[string]int{}
+	for _, value := range samples(t, 2000, func() (string, error) { return Passphrase(5, "_") }) {
+		words := strings.Split(value, "_")
+		if len(words) != 5 {
+			t.Fatalf("%q does not have 5 words", value)
+		}
+		for _, word := range words {
+			if !known[word] {
+				t.Fatalf("%q is not in the word list", word)
+			}
+			used[word]++
+		}
+	}
+	if len(used) < 1200 { // 10000 draws from 1296 words leave about one word out
+		t.Errorf("only %d distinct words drawn out of 1296", len(used))
+	}
+}
+
+func TestPIN(t *testing.T) {
+	values := samples(t, 2000, func() (string, error) { return PIN(8) })
+	for _, value := range values {
+		if len(value) != 8 || strings.Trim(value, Digits) != "" {
+			t.Fatalf("%q is not an 8 digit PIN", value)
+		}
+	}
+	if bits := entropy(values); bits < math.Log2(10)-0.01 {
+		t.Errorf("PIN entropy %.3f bits per digit, want %.3f", bits, math.Log2(10))
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package awsstore implements the secret store on top of AWS Secrets Manager
+package awsstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+)
+
+// Staging labels used by the AWS Secret Manager
+const (
+	CurrentStage = "AWSCURRENT"
+	PendingStage = "AWSPENDING"
+)
+
+// Description is set on the secrets created by the controller
+const Description = "Secret Managed By AWSGuardian"
+
+// Store is the AWS Secret Manager implementation of secretstore.SecretStore
+// the value of a secret is stored as a JSON object of keys and values
+type Store struct {
+	SecretsManager secretsmanageriface.SecretsManagerAPI
+	STS            stsiface.STSAPI
+}
+
+var _ secretstore.SecretStore = &Store{}
+
+// function to create a store using the AWS clients
+// return the store
+func New(clients *Clients) *Store {
+	return &Store{SecretsManager: clients.SecretsManager, STS: clients.STS}
+}
+
+// function to get the ARN of the user using the AWS STS service
+// return the ARN of the user as a string
+func (s *Store) Identity(ctx context.Context) (string, error) {
+	result, err := s.STS.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(result.Arn), nil
+}
+
+// function to check if the secret already exists in the AWS Secret Manager
+// return true if the secret exists, false if the secret does not exist
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.Describe(ctx, name)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// function to get the ARN, the current version, the pending version, the tags and the description of the secret in the AWS Secret Manager
+// a pending version is only returned if it is not also the current version
+// return the secret info, or secretstore.ErrNotFound if the secret does not exist
+func (s *Store) Describe(ctx context.Context, name string) (*secretstore.SecretInfo, error) {
+	output, err := s.SecretsManager.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	info := &secretstore.SecretInfo{ID: aws.StringValue(output.ARN), Tags: map[string]string{}, Description: aws.StringValue(output.Description)}
+	for versionID, stages := range output.VersionIdsToStages {
+		current, pending := false, false
+		for _, stage := range stages {
+			switch aws.StringValue(stage) {
+			case CurrentStage:
+				current = true
+			case PendingStage:
+				pending = true
+			}
+		}
+		if current {
+			info.CurrentVersionID = versionID
+		} else if pending {
+			info.PendingVersionID = versionID
+		}
+	}
+	for _, tag := range output.Tags {
+		info.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return info, nil
+}
+
+// function to get the value of a version of the secret in the AWS Secret Manager
+// return the value as a map of keys and values
+func (s *Store) Get(ctx context.Context, name string, versionID string) (map[string]string, error) {
+	output, err := s.SecretsManager.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:  aws.String(name),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	value := map[string]string{}
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// function to stage a new value of the secret in the AWS Secret Manager
+// the new version is labeled AWSPENDING only, consumers of AWSCURRENT keep the old value
+// if the secret does not exist, it is created with the tags and without a value first,
+// the AWS Secret Manager labels the first version of a secret AWSCURRENT as well, there is no old value to keep
+// return the ARN of the secret and the version id of the staged value
+func (s *Store) Stage(ctx context.Context, name string, value map[string]string, tags map[string]string) (*secretstore.SecretVersion, error) {
+	secretString, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	input := &secretsmanager.PutSecretValueInput{
+		SecretId:      aws.String(name),
+		SecretString:  aws.String(string(secretString)),
+		VersionStages: []*string{aws.String(PendingStage)},
+	}
+	output, err := s.SecretsManager.PutSecretValueWithContext(ctx, input)
+	if errors.Is(notFound(err), secretstore.ErrNotFound) {
+		_, err = s.SecretsManager.CreateSecretWithContext(ctx, &secretsmanager.CreateSecretInput{ // create the secret without a value, so the value is staged like any other
+			Description: aws.String(Description),
+			Name:        aws.String(name),
+			Tags:        awsTags(tags),
+		})
+		if err != nil {
+			return nil, err
+		}
+		output, err = s.SecretsManager.PutSecretValueWithContext(ctx, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.SecretVersion{ID: aws.StringValue(output.ARN), VersionID: aws.StringValue(output.VersionId)}, nil
+}
+
+// function to promote the staged value of the secret in the AWS Secret Manager
+// moves AWSCURRENT to the pending version and removes the AWSPENDING label from it
+func (s *Store) Promote(ctx context.Context, name string, versionID string) error {
+	info, err := s.Describe(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.CurrentVersionID != versionID { // the first version of a secret is already current
+		input := &secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:        aws.String(name),
+			VersionStage:    aws.String(CurrentStage),
+			MoveToVersionId: aws.String(versionID),
+		}
+		if info.CurrentVersionID != "" {
+			input.RemoveFromVersionId = aws.String(info.CurrentVersionID)
+		}
+		if _, err := s.SecretsManager.UpdateSecretVersionStageWithContext(ctx, input); err != nil {
+			return err
+		}
+	}
+	_, err = s.SecretsManager.UpdateSecretVersionStageWithContext(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(name),
+		VersionStage:        aws.String(PendingStage),
+		RemoveFromVersionId: aws.String(versionID),
+	})
+	return err
+}
+
+// function to delete the secret from the AWS Secret Manager
+// the secret can be restored during the recovery window unless the deletion is forced
+func (s *Store) Delete(ctx context.Context, name string, options secretstore.DeleteOptions) error {
+	input := &secretsmanager.DeleteSecretInput{SecretId: aws.String(name)}
+	if options.Force {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	} else if options.RecoveryWindowInDays > 0 {
+		input.RecoveryWindowInDays = aws.Int64(options.RecoveryWindowInDays)
+	}
+	_, err := s.SecretsManager.DeleteSecretWithContext(ctx, input)
+	return notFound(err)
+}
+
+// function to add tags to the secret in the AWS Secret Manager
+func (s *Store) Tag(ctx context.Context, name string, tags map[string]string) error {
+	_, err := s.SecretsManager.TagResourceWithContext(ctx, &secretsmanager.TagResourceInput{SecretId: aws.String(name), Tags: awsTags(tags)})
+	return notFound(err)
+}
+
+// function to convert tags to the tags of the AWS Secret Manager
+// return the tags, nil without tags
+func awsTags(tags map[string]string) []*secretsmanager.Tag {
+	var awsTags []*secretsmanager.Tag
+	for key, value := range tags {
+		awsTags = append(awsTags, &secretsmanager.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return awsTags
+}
+
+// function to translate the AWS not found error to secretstore.ErrNotFound
+// return the error unchanged for any other error
+func notFound(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return fmt.Errorf("%w: %s", secretstore.ErrNotFound, err)
+	}
+	return err
+}
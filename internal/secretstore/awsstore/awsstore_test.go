@@ -0,0 +1,328 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+)
+
+// mockSecretsManager keeps secrets in memory and moves their staging labels like the AWS Secret Manager
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]*mockSecret
+	deleted []*secretsmanager.DeleteSecretInput
+}
+
+type mockSecret struct {
+	description string
+	values      map[string]string   // secret string by version id
+	stages      map[string][]string // staging labels by version id
+	tags        map[string]string
+}
+
+func newMockSecretsManager() *mockSecretsManager {
+	return &mockSecretsManager{secrets: map[string]*mockSecret{}}
+}
+
+// function to get a secret of the mock
+// return the secret, or the AWS not found error
+func (m *mockSecretsManager) secret(name *string) (*mockSecret, error) {
+	secret, ok := m.secrets[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	return secret, nil
+}
+
+// function to get the version holding the staging label
+// return the version id, or an empty string if no version holds the label
+func (s *mockSecret) versionOf(stage string) string {
+	for versionID, stages := range s.stages {
+		for _, label := range stages {
+			if label == stage {
+				return versionID
+			}
+		}
+	}
+	return ""
+}
+
+// function to move or remove a staging label, like UpdateSecretVersionStage
+func (s *mockSecret) moveStage(stage string, to string) {
+	for versionID, stages := range s.stages {
+		var kept []string
+		for _, label := range stages {
+			if label != stage {
+				kept = append(kept, label)
+			}
+		}
+		s.stages[versionID] = kept
+	}
+	if to != "" {
+		s.stages[to] = append(s.stages[to], stage)
+	}
+}
+
+func (m *mockSecretsManager) DescribeSecretWithContext(ctx aws.Context, input *secretsmanager.DescribeSecretInput, opts ...request.Option) (*secretsmanager.DescribeSecretOutput, error) {
+	secret, err := m.secret(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	output := &secretsmanager.DescribeSecretOutput{ARN: aws.String("arn:" + aws.StringValue(input.SecretId)), Description: aws.String(secret.description), VersionIdsToStages: map[string][]*string{}}
+	for versionID, stages := range secret.stages {
+		output.VersionIdsToStages[versionID] = aws.StringSlice(stages)
+	}
+	for key, value := range secret.tags {
+		output.Tags = append(output.Tags, &secretsmanager.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return output, nil
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	secret, err := m.secret(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.values[aws.StringValue(input.VersionId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "version not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value), VersionId: input.VersionId}, nil
+}
+
+func (m *mockSecretsManager) CreateSecretWithContext(ctx aws.Context, input *secretsmanager.CreateSecretInput, opts ...request.Option) (*secretsmanager.CreateSecretOutput, error) {
+	if _, ok := m.secrets[aws.StringValue(input.Name)]; ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "secret exists", nil)
+	}
+	secret := &mockSecret{
+		description: aws.StringValue(input.Description),
+		values:      map[string]string{},
+		stages:      map[string][]string{},
+		tags:        map[string]string{},
+	}
+	for _, tag := range input.Tags {
+		secret.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	m.secrets[aws.StringValue(input.Name)] = secret
+	return &secretsmanager.CreateSecretOutput{ARN: aws.String("arn:" + aws.StringValue(input.Name))}, nil
+}
+
+func (m *mockSecretsManager) PutSecretValueWithContext(ctx aws.Context, input *secretsmanager.PutSecretValueInput, opts ...request.Option) (*secretsmanager.PutSecretValueOutput, error) {
+	secret, err := m.secret(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	versionID := fmt.Sprintf("v%d", len(secret.values)+1)
+	first := len(secret.values) == 0
+	secret.values[versionID] = aws.StringValue(input.SecretString)
+	stages := aws.StringValueSlice(input.VersionStages)
+	if len(stages) == 0 || (first && stages[0] != CurrentStage) { // the first version of a secret is always current
+		stages = append(stages, CurrentStage)
+	}
+	for _, stage := range stages {
+		secret.moveStage(stage, versionID)
+	}
+	return &secretsmanager.PutSecretValueOutput{ARN: aws.String("arn:" + aws.StringValue(input.SecretId)), VersionId: aws.String(versionID)}, nil
+}
+
+func (m *mockSecretsManager) UpdateSecretVersionStageWithContext(ctx aws.Context, input *secretsmanager.UpdateSecretVersionStageInput, opts ...request.Option) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	secret, err := m.secret(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	stage := aws.StringValue(input.VersionStage)
+	holder := secret.versionOf(stage)
+	if holder != "" && holder != aws.StringValue(input.MoveToVersionId) && holder != aws.StringValue(input.RemoveFromVersionId) {
+		return nil, awserr.New(secretsmanager.ErrCodeInvalidParameterException, stage+" is attached to version "+holder, nil)
+	}
+	secret.moveStage(stage, aws.StringValue(input.MoveToVersionId))
+	return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
+}
+
+func (m *mockSecretsManager) DeleteSecretWithContext(ctx aws.Context, input *secretsmanager.DeleteSecretInput, opts ...request.Option) (*secretsmanager.DeleteSecretOutput, error) {
+	if _, err := m.secret(input.SecretId); err != nil {
+		return nil, err
+	}
+	m.deleted = append(m.deleted, input)
+	delete(m.secrets, aws.StringValue(input.SecretId))
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) TagResourceWithContext(ctx aws.Context, input *secretsmanager.TagResourceInput, opts ...request.Option) (*secretsmanager.TagResourceOutput, error) {
+	secret, err := m.secret(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range input.Tags {
+		secret.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+func TestStageAndPromote(t *testing.T) {
+	ctx := context.Background()
+	secretsManager := newMockSecretsManager()
+	store := &Store{SecretsManager: secretsManager}
+
+	if _, err := store.Describe(ctx, "db"); !errors.Is(err, secretstore.ErrNotFound) {
+		t.Fatalf("Describe of a missing secret: %v, want ErrNotFound", err)
+	}
+
+	first, err := store.Stage(ctx, "db", map[string]string{"password": "first"}, map[string]string{"owner": "default/guardian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretsManager.secrets["db"].description != Description {
+		t.Errorf("description %q, want %q", secretsManager.secrets["db"].description, Description)
+	}
+	info, err := store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != first.ID || info.CurrentVersionID != first.VersionID || info.PendingVersionID != "" {
+		t.Errorf("Describe after the first Stage = %+v, want the first version %s current right away", info, first.VersionID)
+	}
+	if info.Tags["owner"] != "default/guardian" || info.Description != Description {
+		t.Errorf("Describe after the first Stage = %+v, want the secret created with its tags and description", info)
+	}
+	if err := store.Promote(ctx, "db", first.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if stages := secretsManager.secrets["db"].stages[first.VersionID]; len(stages) != 1 || stages[0] != CurrentStage {
+		t.Errorf("stages of the promoted first version %v, want only %s", stages, CurrentStage)
+	}
+
+	second, err := store.Stage(ctx, "db", map[string]string{"password": "second"}, map[string]string{"owner": "default/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CurrentVersionID != first.VersionID || info.PendingVersionID != second.VersionID || info.Tags["owner"] != "default/guardian" {
+		t.Errorf("Describe after the second Stage = %+v, want current %s, pending %s and the tags of the creation", info, first.VersionID, second.VersionID)
+	}
+	if stages := secretsManager.secrets["db"].stages[second.VersionID]; len(stages) != 1 || stages[0] != PendingStage {
+		t.Errorf("stages of the staged version %v, want only %s", stages, PendingStage)
+	}
+	if value, err := store.Get(ctx, "db", info.CurrentVersionID); err != nil || value["password"] != "first" {
+		t.Errorf("current value %v, %v, want the value before the promotion", value, err)
+	}
+
+	if err := store.Promote(ctx, "db", second.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	info, err = store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CurrentVersionID != second.VersionID || info.PendingVersionID != "" {
+		t.Errorf("Describe after the second Promote = %+v, want current %s and no pending version", info, second.VersionID)
+	}
+	if value, err := store.Get(ctx, "db", info.CurrentVersionID); err != nil || value["password"] != "second" {
+		t.Errorf("current value %v, %v, want the promoted value", value, err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	secretsManager := newMockSecretsManager()
+	secretsManager.secrets["db"] = &mockSecret{
+		stages: map[string][]string{
+			"v1": {"AWSPREVIOUS"},
+			"v2": {CurrentStage, PendingStage}, // an interrupted promotion, the version is current
+		},
+		tags: map[string]string{"team": "a"},
+	}
+	info, err := (&Store{SecretsManager: secretsManager}).Describe(context.Background(), "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "arn:db" || info.CurrentVersionID != "v2" || info.PendingVersionID != "" || info.Tags["team"] != "a" {
+		t.Errorf("Describe = %+v", info)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cases := map[string]struct {
+		options      secretstore.DeleteOptions
+		wantForce    bool
+		wantRecovery int64
+	}{
+		"default recovery window": {},
+		"recovery window":         {options: secretstore.DeleteOptions{RecoveryWindowInDays: 7}, wantRecovery: 7},
+		"force":                   {options: secretstore.DeleteOptions{Force: true, RecoveryWindowInDays: 7}, wantForce: true},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			secretsManager := newMockSecretsManager()
+			store := &Store{SecretsManager: secretsManager}
+			if _, err := store.Stage(ctx, "db", map[string]string{"password": "value"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ctx, "db", tt.options); err != nil {
+				t.Fatal(err)
+			}
+			if len(secretsManager.deleted) != 1 {
+				t.Fatalf("%d deletions, want 1", len(secretsManager.deleted))
+			}
+			input := secretsManager.deleted[0]
+			if aws.BoolValue(input.ForceDeleteWithoutRecovery) != tt.wantForce || aws.Int64Value(input.RecoveryWindowInDays) != tt.wantRecovery {
+				t.Errorf("DeleteSecret input %v", input)
+			}
+			if err := store.Delete(ctx, "db", tt.options); !errors.Is(err, secretstore.ErrNotFound) {
+				t.Errorf("Delete of a deleted secret: %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTag(t *testing.T) {
+	ctx := context.Background()
+	store := &Store{SecretsManager: newMockSecretsManager()}
+	if err := store.Tag(ctx, "db", map[string]string{"owner": "default/guardian"}); !errors.Is(err, secretstore.ErrNotFound) {
+		t.Errorf("Tag of a missing secret: %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stage(ctx, "db", map[string]string{"password": "value"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, "db", map[string]string{"owner": "default/guardian"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, "db", map[string]string{"team": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Tags["owner"] != "default/guardian" || info.Tags["team"] != "a" {
+		t.Errorf("tags %v, want the tags of both calls", info.Tags)
+	}
+}
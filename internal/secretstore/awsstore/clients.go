@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsstore
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// CredentialsID identifies credentials in the client cache
+type CredentialsID struct {
+	Source  string // where the credentials come from, e.g. the credentials Secret
+	Version string // changes when the credentials of the source change, e.g. the resource version of the Secret
+	Owner   string // guardian the credentials are private to, e.g. an assumed role session, empty if shared
+}
+
+// Clients holds the AWS clients of one region and identity
+type Clients struct {
+	Credentials    *credentials.Credentials
+	STS            stsiface.STSAPI
+	SecretsManager secretsmanageriface.SecretsManagerAPI
+}
+
+// ClientCache keeps AWS clients between reconciles, keyed by region and identity
+// credentials are passed to each session explicitly, so guardians with different identities can be reconciled concurrently
+type ClientCache struct {
+	// NewClients creates the clients of a region with the given credentials, defaults to NewClients
+	NewClients func(region string, creds *credentials.Credentials) (*Clients, error)
+
+	mu    sync.Mutex
+	items map[string]*cachedClients
+}
+
+type cachedClients struct {
+	region   string
+	identity CredentialsID
+	clients  *Clients
+}
+
+// function to get the cached AWS clients of the region and identity, or create and cache them
+// creating clients for a new version of a source drops the clients of the previous versions,
+// an owner has a single client, creating clients for a new version or region of the owner drops the previous clients
+// return the AWS clients
+func (c *ClientCache) GetOrCreate(region string, identity CredentialsID, newCredentials func() (*credentials.Credentials, error)) (*Clients, error) {
+	key := region + "|" + identity.Source + "@" + identity.Version + "#" + identity.Owner
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.items[key]; ok {
+		return item.clients, nil
+	}
+
+	creds, err := newCredentials()
+	if err != nil {
+		return nil, err
+	}
+	newClients := c.NewClients
+	if newClients == nil {
+		newClients = NewClients
+	}
+	clients, err := newClients(region, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.items == nil {
+		c.items = map[string]*cachedClients{}
+	}
+	for cachedKey, item := range c.items { // the credentials of the source changed, the old clients must not be used anymore
+		if item.identity.Source != identity.Source || item.identity.Owner != identity.Owner {
+			continue
+		}
+		if item.identity.Version != identity.Version || (identity.Owner != "" && item.region != region) {
+			delete(c.items, cachedKey)
+		}
+	}
+	c.items[key] = &cachedClients{region: region, identity: identity, clients: clients}
+	return clients, nil
+}
+
+// function to drop every cached AWS client created from the source
+// used when the credentials Secret of the source is deleted
+func (c *ClientCache) Invalidate(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cachedKey, item := range c.items {
+		if item.identity.Source == source || strings.HasPrefix(item.identity.Source, source+"|") {
+			delete(c.items, cachedKey)
+		}
+	}
+}
+
+// function to drop every cached AWS client private to the owner
+// used when the guardian owning them is deleted
+func (c *ClientCache) InvalidateOwner(owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cachedKey, item := range c.items {
+		if item.identity.Owner == owner {
+			delete(c.items, cachedKey)
+		}
+	}
+}
+
+// function to create the AWS clients of the region with the given credentials
+// return the STS and Secret Manager clients sharing one session
+func NewClients(region string, creds *credentials.Credentials) (*Clients, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: creds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Clients{
+		Credentials:    creds,
+		STS:            sts.New(sess),
+		SecretsManager: secretsmanager.New(sess),
+	}, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsstore
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// function to create a client cache counting the clients it creates
+// return the cache and the function getting the clients of an identity, which reports if they were created
+func newTestCache(t *testing.T) (*ClientCache, func(region string, identity CredentialsID) (*Clients, bool)) {
+	t.Helper()
+	cache := &ClientCache{NewClients: func(region string, creds *credentials.Credentials) (*Clients, error) {
+		return &Clients{Credentials: creds}, nil
+	}}
+	return cache, func(region string, identity CredentialsID) (*Clients, bool) {
+		var created bool
+		clients, err := cache.GetOrCreate(region, identity, func() (*credentials.Credentials, error) {
+			created = true
+			return credentials.NewStaticCredentials(identity.Source, identity.Version, ""), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clients, created
+	}
+}
+
+func TestClientCacheReusesClients(t *testing.T) {
+	_, get := newTestCache(t)
+	identity := CredentialsID{Source: "static:default/team-creds", Version: "1"}
+
+	first, created := get("eu-west-1", identity)
+	if !created {
+		t.Fatal("the clients were not created")
+	}
+	if second, created := get("eu-west-1", identity); created || second != first {
+		t.Error("the cached clients were not reused")
+	}
+	if _, created := get("us-east-1", identity); !created {
+		t.Error("the clients of another region were reused")
+	}
+}
+
+func TestClientCacheEvictsOldVersions(t *testing.T) {
+	_, get := newTestCache(t)
+	v1 := CredentialsID{Source: "static:default/team-creds", Version: "1"}
+	other := CredentialsID{Source: "static:default/other-creds", Version: "1"}
+	get("eu-west-1", v1)
+	get("eu-west-1", other)
+
+	if _, created := get("eu-west-1", CredentialsID{Source: v1.Source, Version: "2"}); !created {
+		t.Fatal("the clients of the new version were not created")
+	}
+	if _, created := get("eu-west-1", v1); !created {
+		t.Error("the clients of the previous version are still cached")
+	}
+	if _, created := get("eu-west-1", other); created {
+		t.Error("the clients of another source were evicted")
+	}
+}
+
+func TestClientCacheInvalidate(t *testing.T) {
+	cache, get := newTestCache(t)
+	base := CredentialsID{Source: "static:default/team", Version: "1"}
+	assumed := CredentialsID{Source: base.Source + "|assume-role:abc", Version: "1"}
+	prefixed := CredentialsID{Source: "static:default/team-b", Version: "1"}
+	for _, identity := range []CredentialsID{base, assumed, prefixed} {
+		get("eu-west-1", identity)
+	}
+
+	cache.Invalidate(base.Source)
+	if _, created := get("eu-west-1", base); !created {
+		t.Error("the clients of the source are still cached")
+	}
+	if _, created := get("eu-west-1", assumed); !created {
+		t.Error("the clients of the role assumed with the source are still cached")
+	}
+	if _, created := get("eu-west-1", prefixed); created {
+		t.Error("the clients of another source sharing the prefix were dropped")
+	}
+}
+
+func TestClientCacheOwners(t *testing.T) {
+	cache, get := newTestCache(t)
+	a := CredentialsID{Source: "static:default/team|assume-role", Version: "1|abc", Owner: "default/a"}
+	b := CredentialsID{Source: a.Source, Version: a.Version, Owner: "default/b"}
+	get("eu-west-1", a)
+	if _, created := get("eu-west-1", b); !created {
+		t.Fatal("the clients of another owner were reused")
+	}
+
+	if _, created := get("us-east-1", a); !created {
+		t.Fatal("the clients of the new region were not created")
+	}
+	if _, created := get("eu-west-1", a); !created {
+		t.Error("the clients of the previous region of the owner are still cached")
+	}
+	if _, created := get("eu-west-1", CredentialsID{Source: a.Source, Version: "1|def", Owner: a.Owner}); !created {
+		t.Fatal("the clients of the changed role were not created")
+	}
+	if _, created := get("eu-west-1", b); created {
+		t.Error("the clients of another owner were evicted")
+	}
+
+	cache.InvalidateOwner(b.Owner)
+	if _, created := get("eu-west-1", b); !created {
+		t.Error("the clients of the owner are still cached")
+	}
+}
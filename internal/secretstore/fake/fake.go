@@ -0,0 +1,225 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake implements an in-memory secret store for tests
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+)
+
+// Store is an in-memory secretstore.SecretStore
+// Errors can be set to make a method fail, keyed by the method name, e.g. "Promote"
+type Store struct {
+	Errors map[string]error
+
+	mu      sync.Mutex
+	secrets map[string]*Secret
+	version int
+}
+
+// Secret is a secret held by the fake store
+type Secret struct {
+	Versions         map[string]map[string]string
+	CurrentVersionID string
+	PendingVersionID string
+	Tags             map[string]string
+	Description      string
+	Deleted          bool
+	DeleteOptions    secretstore.DeleteOptions
+}
+
+var _ secretstore.SecretStore = &Store{}
+
+// function to create an empty fake store
+// return the store
+func New() *Store {
+	return &Store{Errors: map[string]error{}, secrets: map[string]*Secret{}}
+}
+
+// function to get a secret of the store for assertions
+// return nil if the secret does not exist
+func (s *Store) Secret(name string) *Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets[name]
+}
+
+// function to get the current value of a secret for assertions
+// return nil if the secret has no current value
+func (s *Store) Current(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.CurrentVersionID == "" {
+		return nil
+	}
+	return secret.Versions[secret.CurrentVersionID]
+}
+
+// function to get the identity of the fake store
+// return "fake"
+func (s *Store) Identity(ctx context.Context) (string, error) {
+	if err := s.Errors["Identity"]; err != nil {
+		return "", err
+	}
+	return "fake", nil
+}
+
+// function to check if the secret exists in the fake store
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	if err := s.Errors["Exists"]; err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	return ok && !secret.Deleted, nil
+}
+
+// function to get the current version, the pending version and the tags of the secret
+// return secretstore.ErrNotFound if the secret does not exist
+func (s *Store) Describe(ctx context.Context, name string) (*secretstore.SecretInfo, error) {
+	if err := s.Errors["Describe"]; err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		return nil, secretstore.ErrNotFound
+	}
+	tags := make(map[string]string, len(secret.Tags))
+	for key, value := range secret.Tags {
+		tags[key] = value
+	}
+	return &secretstore.SecretInfo{
+		ID:               "fake:" + name,
+		CurrentVersionID: secret.CurrentVersionID,
+		PendingVersionID: secret.PendingVersionID,
+		Tags:             tags,
+		Description:      secret.Description,
+	}, nil
+}
+
+// function to get the value of a version of the secret
+// return the value as a map of keys and values
+func (s *Store) Get(ctx context.Context, name string, versionID string) (map[string]string, error) {
+	if err := s.Errors["Get"]; err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		return nil, secretstore.ErrNotFound
+	}
+	value, ok := secret.Versions[versionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: version %s", secretstore.ErrNotFound, versionID)
+	}
+	return value, nil
+}
+
+// function to stage a new value of the secret, creating the secret if needed
+// a new secret is created with the tags and, like the AWS Secret Manager, its first version is current right away
+// return the id of the secret and the version id of the staged value
+func (s *Store) Stage(ctx context.Context, name string, value map[string]string, tags map[string]string) (*secretstore.SecretVersion, error) {
+	if err := s.Errors["Stage"]; err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		secret = &Secret{Versions: map[string]map[string]string{}, Tags: map[string]string{}}
+		for key, val := range tags {
+			secret.Tags[key] = val
+		}
+		s.secrets[name] = secret
+	}
+	s.version++
+	versionID := fmt.Sprintf("v%d", s.version)
+	staged := make(map[string]string, len(value))
+	for key, val := range value {
+		staged[key] = val
+	}
+	secret.Versions[versionID] = staged
+	if secret.CurrentVersionID == "" {
+		secret.CurrentVersionID = versionID
+	} else {
+		secret.PendingVersionID = versionID
+	}
+	return &secretstore.SecretVersion{ID: "fake:" + name, VersionID: versionID}, nil
+}
+
+// function to make the staged version of the secret current
+func (s *Store) Promote(ctx context.Context, name string, versionID string) error {
+	if err := s.Errors["Promote"]; err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		return secretstore.ErrNotFound
+	}
+	if _, ok := secret.Versions[versionID]; !ok {
+		return fmt.Errorf("%w: version %s", secretstore.ErrNotFound, versionID)
+	}
+	secret.CurrentVersionID = versionID
+	if secret.PendingVersionID == versionID {
+		secret.PendingVersionID = ""
+	}
+	return nil
+}
+
+// function to mark the secret as deleted, the delete options are kept for assertions
+func (s *Store) Delete(ctx context.Context, name string, options secretstore.DeleteOptions) error {
+	if err := s.Errors["Delete"]; err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		return secretstore.ErrNotFound
+	}
+	secret.Deleted = true
+	secret.DeleteOptions = options
+	return nil
+}
+
+// function to add tags to the secret
+func (s *Store) Tag(ctx context.Context, name string, tags map[string]string) error {
+	if err := s.Errors["Tag"]; err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[name]
+	if !ok || secret.Deleted {
+		return secretstore.ErrNotFound
+	}
+	for key, value := range tags {
+		secret.Tags[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secretstore defines the backends the controller writes rotated secrets to
+package secretstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when the secret does not exist in the store
+var ErrNotFound = errors.New("secret not found")
+
+// SecretStore is a backend holding the rotated secret values
+// a rotation stages a new version, writes it to the k8s secret and only then promotes it,
+// so the store and the k8s secret never hold different current values
+type SecretStore interface {
+	// Identity returns the identity the store is accessed with, used to check the store is reachable
+	Identity(ctx context.Context) (string, error)
+
+	// Exists returns true if the secret exists in the store
+	Exists(ctx context.Context, name string) (bool, error)
+
+	// Describe returns the id, the versions and the tags of the secret, or ErrNotFound
+	Describe(ctx context.Context, name string) (*SecretInfo, error)
+
+	// Get returns the value of a version of the secret
+	Get(ctx context.Context, name string, versionID string) (map[string]string, error)
+
+	// Stage writes a new value of the secret without making it current, the secret is created with the tags if needed,
+	// so a new secret is never seen without them
+	Stage(ctx context.Context, name string, value map[string]string, tags map[string]string) (*SecretVersion, error)
+
+	// Promote makes a staged version the current version of the secret
+	Promote(ctx context.Context, name string, versionID string) error
+
+	// Delete deletes the secret from the store
+	Delete(ctx context.Context, name string, options DeleteOptions) error
+
+	// Tag adds the tags to the secret
+	Tag(ctx context.Context, name string, tags map[string]string) error
+}
+
+// SecretInfo describes a secret in the store
+type SecretInfo struct {
+	ID               string            // id of the secret in the store, e.g. the ARN in AWS
+	CurrentVersionID string            // version id of the current value, empty if the secret has no value yet
+	PendingVersionID string            // version id of a staged value that was not promoted, empty if there is none
+	Tags             map[string]string // tags of the secret
+	Description      string            // description of the secret, empty if the store has none
+}
+
+// SecretVersion identifies a version of a secret
+type SecretVersion struct {
+	ID        string // id of the secret in the store
+	VersionID string // id of the version
+}
+
+// DeleteOptions configures how a secret is deleted
+type DeleteOptions struct {
+	RecoveryWindowInDays int64 // days the secret can be restored, the store default is used when 0
+	Force                bool  // delete the secret without any recovery window
+}
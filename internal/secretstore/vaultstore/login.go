@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginAuth is the auth field of a login response
+type loginAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+}
+
+// Token is a Vault token obtained with a login
+type Token struct {
+	Token     string
+	ExpiresAt time.Time // zero if the token does not expire
+}
+
+// function to log in to Vault with an auth method, e.g. kubernetes or approle
+// the payload is the login body of the auth method, e.g. role and jwt for kubernetes
+// return the client token and its expiry
+func Login(ctx context.Context, httpClient *http.Client, address string, namespace string, mountPath string, payload map[string]string) (*Token, error) {
+	response, err := request(ctx, httpClient, http.MethodPost, address, namespace, "", "auth/"+strings.Trim(mountPath, "/")+"/login", payload)
+	if err != nil {
+		return nil, err
+	}
+	if response.Auth == nil || response.Auth.ClientToken == "" {
+		return nil, errors.New("vault login returned no client token")
+	}
+	token := &Token{Token: response.Auth.ClientToken}
+	if response.Auth.LeaseDuration > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second)
+	}
+	return token, nil
+}
+
+// TokenCache holds the Vault tokens of the guardians
+// a token is reused until 90% of its lease has passed, so the controller does not log in on every reconcile
+type TokenCache struct {
+	mu    sync.Mutex
+	items map[string]*cachedToken
+}
+
+type cachedToken struct {
+	token       *Token
+	fingerprint string
+	refreshAt   time.Time
+}
+
+// function to get the cached token of the key or log in to get a new one
+// the fingerprint identifies the login, e.g. the auth method and the version of the Secret holding the secret id,
+// a cached token with another fingerprint is replaced
+// return the token
+func (c *TokenCache) GetOrLogin(key string, fingerprint string, login func() (*Token, error)) (*Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items == nil {
+		c.items = map[string]*cachedToken{}
+	}
+	if cached, ok := c.items[key]; ok && cached.fingerprint == fingerprint && (cached.refreshAt.IsZero() || time.Now().Before(cached.refreshAt)) {
+		return cached.token, nil
+	}
+	token, err := login()
+	if err != nil {
+		delete(c.items, key)
+		return nil, err
+	}
+	cached := &cachedToken{token: token, fingerprint: fingerprint}
+	if !token.ExpiresAt.IsZero() {
+		cached.refreshAt = time.Now().Add(time.Until(token.ExpiresAt) * 9 / 10)
+	}
+	c.items[key] = cached
+	return token, nil
+}
+
+// function to drop the cached token of the key, e.g. after Vault rejected it
+func (c *TokenCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vaultstore implements the secret store on top of a HashiCorp Vault KV v2 secrets engine
+package vaultstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+)
+
+// Custom metadata keys used to track the rotation of a secret
+// KV v2 has no staging labels, so the version the controller considers current and the version it has staged
+// are recorded in the custom metadata of the secret, the KV version holding the current value is recorded in PromotedVersionKey
+const (
+	CurrentVersionKey  = "guardian-current-version"
+	PendingVersionKey  = "guardian-pending-version"
+	PromotedVersionKey = "guardian-promoted-version"
+)
+
+// PendingPath is the path of the mount under which the staged values are written until they are promoted
+const PendingPath = "guardian-pending"
+
+// DefaultHTTPClient is used by the stores and logins without an HTTP client
+var DefaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Store is the Vault KV v2 implementation of secretstore.SecretStore
+// the secret name is the path of the secret in the mount, after PathPrefix
+// KV v2 can not hide a version, so a staged value is written under PendingPath and only written to the secret by Promote,
+// readers of the secret never see a value before the Kubernetes Secret holds it
+type Store struct {
+	Address    string       // address of the Vault server
+	Namespace  string       // Vault Enterprise namespace, if any
+	Mount      string       // path of the KV v2 secrets engine
+	PathPrefix string       // prepended to the secret name
+	Token      string       // Vault token used for every request
+	HTTPClient *http.Client // defaults to DefaultHTTPClient
+}
+
+var _ secretstore.SecretStore = &Store{}
+
+// metadata is the response of the KV v2 metadata endpoint
+type metadata struct {
+	CurrentVersion int               `json:"current_version"`
+	CustomMetadata map[string]string `json:"custom_metadata"`
+}
+
+// function to get the display name of the token using the token lookup endpoint
+// return the display name of the token
+func (s *Store) Identity(ctx context.Context) (string, error) {
+	var lookup struct {
+		DisplayName string `json:"display_name"`
+	}
+	if err := s.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &lookup); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vault:%s", lookup.DisplayName), nil
+}
+
+// function to check if the secret already exists in the KV mount
+// return true if the secret exists, false if the secret does not exist
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.metadata(ctx, name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// function to get the path, the current version, the pending version and the tags of the secret
+// a secret written outside of the controller has no current version in its custom metadata,
+// its latest version is reported as current
+// return the secret info, or secretstore.ErrNotFound if the secret does not exist
+func (s *Store) Describe(ctx context.Context, name string) (*secretstore.SecretInfo, error) {
+	meta, err := s.metadata(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info := &secretstore.SecretInfo{
+		ID:               s.Address + "/v1/" + s.dataPath(name),
+		CurrentVersionID: meta.CustomMetadata[CurrentVersionKey],
+		PendingVersionID: meta.CustomMetadata[PendingVersionKey],
+		Tags:             map[string]string{},
+	}
+	if info.CurrentVersionID == "" && meta.CurrentVersion > 0 {
+		info.CurrentVersionID = strconv.Itoa(meta.CurrentVersion)
+	}
+	for key, value := range meta.CustomMetadata {
+		if key != CurrentVersionKey && key != PendingVersionKey && key != PromotedVersionKey {
+			info.Tags[key] = value
+		}
+	}
+	return info, nil
+}
+
+// function to get the value of a version of the secret
+// the pending version is read from PendingPath, the current version from the KV version it was promoted to,
+// any other version is a KV version of the secret
+// return the value as a map of keys and values
+func (s *Store) Get(ctx context.Context, name string, versionID string) (map[string]string, error) {
+	meta, err := s.metadata(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case versionID == meta.CustomMetadata[PendingVersionKey]:
+		value, err := s.read(ctx, s.pendingDataPath(name), versionID)
+		if !errors.Is(err, secretstore.ErrNotFound) {
+			return value, err
+		}
+		// staged by an earlier version of the controller as a KV version of the secret
+	case versionID == meta.CustomMetadata[CurrentVersionKey] && meta.CustomMetadata[PromotedVersionKey] != "":
+		versionID = meta.CustomMetadata[PromotedVersionKey]
+	}
+	return s.read(ctx, s.dataPath(name), versionID)
+}
+
+// function to read a KV version at the data path
+// return the value as a map of keys and values, or secretstore.ErrNotFound if the version is deleted
+func (s *Store) read(ctx context.Context, dataPath string, versionID string) (map[string]string, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, dataPath+"?version="+url.QueryEscape(versionID), nil, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data == nil { // deleted versions are returned without data
+		return nil, fmt.Errorf("%w: version %s of %s is deleted", secretstore.ErrNotFound, versionID, dataPath)
+	}
+	value := make(map[string]string, len(secret.Data))
+	for key, val := range secret.Data {
+		if str, ok := val.(string); ok {
+			value[key] = str
+		} else {
+			value[key] = fmt.Sprint(val)
+		}
+	}
+	return value, nil
+}
+
+// function to stage a new value of the secret
+// the value is written as a new KV version under PendingPath and recorded as pending in the custom metadata of the secret,
+// the secret itself is not changed until the version is promoted
+// a new secret is created with the tags in its custom metadata and without any version
+// return the path of the secret and the staged version
+func (s *Store) Stage(ctx context.Context, name string, value map[string]string, tags map[string]string) (*secretstore.SecretVersion, error) {
+	_, err := s.metadata(ctx, name)
+	if err != nil && !errors.Is(err, secretstore.ErrNotFound) {
+		return nil, err
+	}
+	exists := err == nil
+	versionID, err := s.write(ctx, s.pendingDataPath(name), value)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		err = s.updateCustomMetadata(ctx, name, func(customMetadata map[string]string) {
+			customMetadata[PendingVersionKey] = versionID
+		})
+	} else {
+		customMetadata := map[string]string{PendingVersionKey: versionID}
+		for key, value := range tags {
+			customMetadata[key] = value
+		}
+		err = s.do(ctx, http.MethodPost, s.metadataPath(name), map[string]interface{}{"custom_metadata": customMetadata}, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.SecretVersion{ID: s.Address + "/v1/" + s.dataPath(name), VersionID: versionID}, nil
+}
+
+// function to promote the staged version of the secret
+// the staged value is written as a new KV version of the secret, recorded as current with the KV version it was written to,
+// then destroyed under PendingPath
+// a version promoted before is only destroyed, so an interrupted promotion is resumed without writing the value twice
+func (s *Store) Promote(ctx context.Context, name string, versionID string) error {
+	meta, err := s.metadata(ctx, name)
+	if err != nil {
+		return err
+	}
+	if meta.CustomMetadata[CurrentVersionKey] != versionID || meta.CustomMetadata[PromotedVersionKey] == "" {
+		value, err := s.read(ctx, s.pendingDataPath(name), versionID)
+		if err != nil && !errors.Is(err, secretstore.ErrNotFound) {
+			return err
+		}
+		promotedVersionID := "" // staged by an earlier version of the controller, the value is already a KV version of the secret
+		if err == nil {
+			if promotedVersionID, err = s.write(ctx, s.dataPath(name), value); err != nil {
+				return err
+			}
+		}
+		err = s.updateCustomMetadata(ctx, name, func(customMetadata map[string]string) {
+			customMetadata[CurrentVersionKey] = versionID
+			if promotedVersionID != "" {
+				customMetadata[PromotedVersionKey] = promotedVersionID
+			} else {
+				delete(customMetadata, PromotedVersionKey)
+			}
+			if customMetadata[PendingVersionKey] == versionID {
+				delete(customMetadata, PendingVersionKey)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	version, err := strconv.Atoi(versionID)
+	if err != nil {
+		return fmt.Errorf("version %q of %s: %w", versionID, name, err)
+	}
+	err = s.do(ctx, http.MethodPost, s.pendingDestroyPath(name), map[string]interface{}{"versions": []int{version}}, nil)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// function to delete the secret from the KV mount
+// a forced deletion removes the metadata and every version, otherwise the latest version is soft deleted
+// and can be undeleted, the recovery window does not apply to Vault
+// the staged values are deleted the same way
+func (s *Store) Delete(ctx context.Context, name string, options secretstore.DeleteOptions) error {
+	paths := []string{s.dataPath(name), s.pendingDataPath(name)}
+	if options.Force {
+		paths = []string{s.metadataPath(name), s.pendingMetadataPath(name)}
+	}
+	for _, path := range paths {
+		if err := s.do(ctx, http.MethodDelete, path, nil, nil); err != nil && !errors.Is(err, secretstore.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// function to add tags to the custom metadata of the secret
+func (s *Store) Tag(ctx context.Context, name string, tags map[string]string) error {
+	return s.updateCustomMetadata(ctx, name, func(customMetadata map[string]string) {
+		for key, value := range tags {
+			customMetadata[key] = value
+		}
+	})
+}
+
+// function to get the metadata of the secret
+// return secretstore.ErrNotFound if the secret does not exist
+func (s *Store) metadata(ctx context.Context, name string) (*metadata, error) {
+	meta := &metadata{}
+	if err := s.do(ctx, http.MethodGet, s.metadataPath(name), nil, meta); err != nil {
+		return nil, err
+	}
+	if meta.CustomMetadata == nil {
+		meta.CustomMetadata = map[string]string{}
+	}
+	return meta, nil
+}
+
+// function to write a value as a new KV version at the data path
+// return the written version
+func (s *Store) write(ctx context.Context, dataPath string, value map[string]string) (string, error) {
+	var written struct {
+		Version int `json:"version"`
+	}
+	if err := s.do(ctx, http.MethodPost, dataPath, map[string]interface{}{"data": value}, &written); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(written.Version), nil
+}
+
+// function to change the custom metadata of the secret
+// the metadata endpoint replaces the whole custom metadata, so it is read and written back
+func (s *Store) updateCustomMetadata(ctx context.Context, name string, update func(customMetadata map[string]string)) error {
+	meta, err := s.metadata(ctx, name)
+	if err != nil {
+		return err
+	}
+	update(meta.CustomMetadata)
+	return s.do(ctx, http.MethodPost, s.metadataPath(name), map[string]interface{}{"custom_metadata": meta.CustomMetadata}, nil)
+}
+
+// function to build the path of the secret in the KV mount
+func (s *Store) secretPath(name string) string {
+	return strings.Trim(strings.Trim(s.PathPrefix, "/")+"/"+name, "/")
+}
+
+func (s *Store) dataPath(name string) string {
+	return strings.Trim(s.Mount, "/") + "/data/" + s.secretPath(name)
+}
+
+func (s *Store) metadataPath(name string) string {
+	return strings.Trim(s.Mount, "/") + "/metadata/" + s.secretPath(name)
+}
+
+func (s *Store) pendingDataPath(name string) string {
+	return strings.Trim(s.Mount, "/") + "/data/" + PendingPath + "/" + s.secretPath(name)
+}
+
+func (s *Store) pendingMetadataPath(name string) string {
+	return strings.Trim(s.Mount, "/") + "/metadata/" + PendingPath + "/" + s.secretPath(name)
+}
+
+func (s *Store) pendingDestroyPath(name string) string {
+	return strings.Trim(s.Mount, "/") + "/destroy/" + PendingPath + "/" + s.secretPath(name)
+}
+
+// function to call the Vault HTTP API with the token of the store
+// the data field of the response is decoded into out when out is not nil
+func (s *Store) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	response, err := request(ctx, s.HTTPClient, method, s.Address, s.Namespace, s.Token, path, in)
+	if err != nil {
+		return err
+	}
+	if out == nil || response.Data == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Data, out)
+}
+
+// response is the envelope of the Vault HTTP API responses
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *loginAuth      `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+// function to send a request to the Vault HTTP API
+// a 404 is returned as secretstore.ErrNotFound, any other non 2xx status as an error with the Vault errors
+// return the decoded response, empty for a 204
+func request(ctx context.Context, httpClient *http.Client, method string, address string, namespace string, token string, path string, in interface{}) (*response, error) {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(address, "/")+"/v1/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if httpClient == nil {
+		httpClient = DefaultHTTPClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	decoded := &response{}
+	if res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(decoded); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("vault %s %s: %s: %w", method, path, res.Status, err)
+		}
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: vault %s", secretstore.ErrNotFound, path)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("vault %s %s: %s: %s", method, path, res.Status, strings.Join(decoded.Errors, "; "))
+	}
+	return decoded, nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore"
+	"github.com/omerap12/K8s-Secret-Rotation-Controller/internal/secretstore/vaultstore/vaulttest"
+)
+
+func newTestStore(t *testing.T) (*Store, *vaulttest.Server) {
+	t.Helper()
+	server := vaulttest.NewServer()
+	t.Cleanup(server.Close)
+	return &Store{Address: server.URL, Mount: server.Mount, PathPrefix: "apps", Token: server.Token}, server
+}
+
+func TestStageAndPromote(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t)
+
+	if _, err := store.Describe(ctx, "db"); !errors.Is(err, secretstore.ErrNotFound) {
+		t.Fatalf("Describe of a missing secret: %v, want ErrNotFound", err)
+	}
+	first, err := store.Stage(ctx, "db", map[string]string{"password": "one"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PendingVersionID != first.VersionID || info.CurrentVersionID != "" {
+		t.Fatalf("after stage: current %q pending %q, want pending %q only", info.CurrentVersionID, info.PendingVersionID, first.VersionID)
+	}
+	if secret := server.Secret("apps/db"); secret == nil || len(secret.Versions) != 0 {
+		t.Fatalf("KV secret = %+v, want no version before the promotion", secret)
+	}
+	staged, err := store.Get(ctx, "db", first.VersionID)
+	if err != nil || staged["password"] != "one" {
+		t.Fatalf("Get of the staged version = %v, %v", staged, err)
+	}
+	if err := store.Promote(ctx, "db", first.VersionID); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := store.Stage(ctx, "db", map[string]string{"password": "two"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CurrentVersionID != first.VersionID || info.PendingVersionID != second.VersionID {
+		t.Fatalf("staging changed the current version: current %q pending %q", info.CurrentVersionID, info.PendingVersionID)
+	}
+	latest, err := store.Get(ctx, "db", "0") // readers of the latest version do not see the staged value
+	if err != nil || latest["password"] != "one" {
+		t.Fatalf("Get of the latest version = %v, %v, want the promoted value", latest, err)
+	}
+	current, err := store.Get(ctx, "db", info.CurrentVersionID)
+	if err != nil || current["password"] != "one" {
+		t.Fatalf("Get of the current version = %v, %v, want the promoted value", current, err)
+	}
+	if err := store.Promote(ctx, "db", second.VersionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Promote(ctx, "db", second.VersionID); err != nil { // a resumed promotion does not write the value again
+		t.Fatal(err)
+	}
+
+	current, err = store.Get(ctx, "db", second.VersionID)
+	if err != nil || current["password"] != "two" {
+		t.Fatalf("Get of the promoted version = %v, %v", current, err)
+	}
+	old, err := store.Get(ctx, "db", "1") // the previous value is kept as a KV version
+	if err != nil || old["password"] != "one" {
+		t.Fatalf("Get of the previous version = %v, %v", old, err)
+	}
+	secret := server.Secret("apps/db")
+	if secret == nil || len(secret.Versions) != 2 {
+		t.Fatalf("KV secret = %+v, want 2 versions at apps/db", secret)
+	}
+	if secret.CustomMetadata[CurrentVersionKey] != second.VersionID || secret.CustomMetadata[PromotedVersionKey] != "2" || secret.CustomMetadata[PendingVersionKey] != "" {
+		t.Errorf("custom metadata = %v", secret.CustomMetadata)
+	}
+	if pending := server.Secret(PendingPath + "/apps/db"); pending == nil || len(pending.Versions) != 0 {
+		t.Errorf("pending secret = %+v, want the promoted values destroyed", pending)
+	}
+}
+
+func TestPromoteLegacyStagedVersion(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t)
+	// an earlier version of the controller staged the value as a KV version of the secret
+	if err := store.do(ctx, "POST", store.dataPath("db"), map[string]interface{}{"data": map[string]string{"password": "one"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, "db", map[string]string{PendingVersionKey: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := store.Get(ctx, "db", "1")
+	if err != nil || staged["password"] != "one" {
+		t.Fatalf("Get of the legacy staged version = %v, %v", staged, err)
+	}
+	if err := store.Promote(ctx, "db", "1"); err != nil {
+		t.Fatal(err)
+	}
+	secret := server.Secret("apps/db")
+	if len(secret.Versions) != 1 || secret.CustomMetadata[CurrentVersionKey] != "1" || secret.CustomMetadata[PromotedVersionKey] != "" {
+		t.Errorf("KV secret = %+v, want the legacy version recorded as current", secret)
+	}
+}
+
+func TestDescribeUnmanagedSecret(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	if err := store.do(ctx, "POST", store.dataPath("external"), map[string]interface{}{"data": map[string]string{"k": "v"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Describe(ctx, "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CurrentVersionID != "1" || info.PendingVersionID != "" {
+		t.Errorf("unmanaged secret: current %q pending %q, want the latest version as current", info.CurrentVersionID, info.PendingVersionID)
+	}
+}
+
+func TestStageTagsNewSecret(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	if _, err := store.Stage(ctx, "db", map[string]string{"password": "one"}, map[string]string{"owner": "guardian"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stage(ctx, "db", map[string]string{"password": "two"}, map[string]string{"owner": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Tags["owner"] != "guardian" {
+		t.Errorf("tags %v, want the tags of the creation kept", info.Tags)
+	}
+}
+
+func TestTagsKeepRotationMetadata(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	version, err := store.Stage(ctx, "db", map[string]string{"password": "one"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, "db", map[string]string{"owner": "guardian"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Describe(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Tags["owner"] != "guardian" || info.PendingVersionID != version.VersionID {
+		t.Errorf("tags %v pending %q", info.Tags, info.PendingVersionID)
+	}
+	if _, ok := info.Tags[PendingVersionKey]; ok {
+		t.Error("rotation metadata reported as a tag")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t)
+	if _, err := store.Stage(ctx, "db", map[string]string{"password": "one"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "db", secretstore.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "db", "1"); !errors.Is(err, secretstore.ErrNotFound) {
+		t.Errorf("Get of a soft deleted version: %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "db", secretstore.DeleteOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if server.Secret("apps/db") != nil || server.Secret(PendingPath+"/apps/db") != nil {
+		t.Error("forced delete kept the metadata")
+	}
+}
+
+func TestIdentityRejectsBadToken(t *testing.T) {
+	store, _ := newTestStore(t)
+	store.Token = "wrong"
+	if _, err := store.Identity(context.Background()); err == nil {
+		t.Error("Identity succeeded with a wrong token")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AppRoles["role"] = "secret"
+	server.KubernetesRoles["app"] = "jwt"
+
+	token, err := Login(ctx, nil, server.URL, "", "approle", map[string]string{"role_id": "role", "secret_id": "secret"})
+	if err != nil || token.Token != server.Token || token.ExpiresAt.IsZero() {
+		t.Fatalf("approle login = %+v, %v", token, err)
+	}
+	if _, err := Login(ctx, nil, server.URL, "", "approle", map[string]string{"role_id": "role", "secret_id": "wrong"}); err == nil {
+		t.Error("approle login succeeded with a wrong secret id")
+	}
+	if _, err := Login(ctx, nil, server.URL, "", "kubernetes", map[string]string{"role": "app", "jwt": "jwt"}); err != nil {
+		t.Errorf("kubernetes login: %v", err)
+	}
+
+	cache := &TokenCache{}
+	logins := 0
+	login := func() (*Token, error) {
+		logins++
+		return Login(ctx, nil, server.URL, "", "approle", map[string]string{"role_id": "role", "secret_id": "secret"})
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetOrLogin("default/guardian", "approle:1", login); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("logged in %d times, want the token to be cached", logins)
+	}
+	if _, err := cache.GetOrLogin("default/guardian", "approle:2", login); err != nil || logins != 2 {
+		t.Errorf("changed login did not log in again: %d logins, %v", logins, err)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vaulttest implements an in-process stand-in of the Vault HTTP API for tests
+// it covers the KV v2 endpoints, token lookup and the kubernetes and approle logins
+package vaulttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is an in-process Vault with a single KV v2 mount
+type Server struct {
+	*httptest.Server
+
+	Token           string            // token accepted by the server and returned by the logins
+	Mount           string            // path of the KV v2 mount, "secret" by default
+	AppRoles        map[string]string // secret id by role id, accepted by the approle login
+	KubernetesRoles map[string]string // service account token by role, accepted by the kubernetes login
+
+	mu      sync.Mutex
+	secrets map[string]*Secret
+}
+
+// Secret is a KV v2 secret held by the server
+type Secret struct {
+	Versions       map[int]map[string]interface{}
+	Deleted        map[int]bool
+	CurrentVersion int
+	CustomMetadata map[string]string
+}
+
+// function to start a server, it must be closed by the caller
+// return the server
+func NewServer() *Server {
+	s := &Server{
+		Token:           "root",
+		Mount:           "secret",
+		AppRoles:        map[string]string{},
+		KubernetesRoles: map[string]string{},
+		secrets:         map[string]*Secret{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// function to get a secret of the server for assertions
+// return nil if the secret does not exist
+func (s *Server) Secret(path string) *Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets[path]
+}
+
+// function to serve a request of the Vault HTTP API
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		s.login(w, strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login"), body)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != s.Token {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	if path == "auth/token/lookup-self" {
+		writeData(w, map[string]interface{}{"display_name": "token"})
+		return
+	}
+	if rest, ok := strings.CutPrefix(path, s.Mount+"/data/"); ok {
+		s.data(w, r, rest, body)
+		return
+	}
+	if rest, ok := strings.CutPrefix(path, s.Mount+"/metadata/"); ok {
+		s.metadata(w, r, rest, body)
+		return
+	}
+	if rest, ok := strings.CutPrefix(path, s.Mount+"/destroy/"); ok {
+		s.destroy(w, r, rest, body)
+		return
+	}
+	writeError(w, http.StatusNotFound, "no handler for route")
+}
+
+// function to serve the kubernetes and approle logins
+func (s *Server) login(w http.ResponseWriter, mount string, body map[string]interface{}) {
+	valid := false
+	switch mount {
+	case "kubernetes":
+		role, _ := body["role"].(string)
+		jwt, _ := body["jwt"].(string)
+		expected, ok := s.KubernetesRoles[role]
+		valid = ok && jwt == expected
+	case "approle":
+		roleID, _ := body["role_id"].(string)
+		secretID, _ := body["secret_id"].(string)
+		expected, ok := s.AppRoles[roleID]
+		valid = ok && secretID == expected
+	default:
+		writeError(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	if !valid {
+		writeError(w, http.StatusBadRequest, "invalid credentials")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": s.Token, "lease_duration": 3600},
+	})
+}
+
+// function to serve the KV v2 data endpoint
+func (s *Server) data(w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}) {
+	secret := s.secrets[path]
+	switch r.Method {
+	case http.MethodGet:
+		if secret == nil {
+			writeError(w, http.StatusNotFound, "")
+			return
+		}
+		version := secret.CurrentVersion
+		if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+			version, _ = strconv.Atoi(v)
+		}
+		value, ok := secret.Versions[version]
+		if !ok {
+			writeError(w, http.StatusNotFound, "")
+			return
+		}
+		if secret.Deleted[version] {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"data": map[string]interface{}{"data": nil, "metadata": map[string]interface{}{"version": version}}})
+			return
+		}
+		writeData(w, map[string]interface{}{"data": value, "metadata": map[string]interface{}{"version": version}})
+	case http.MethodPost, http.MethodPut:
+		if secret == nil {
+			secret = &Secret{Versions: map[int]map[string]interface{}{}, Deleted: map[int]bool{}, CustomMetadata: map[string]string{}}
+			s.secrets[path] = secret
+		}
+		value, _ := body["data"].(map[string]interface{})
+		secret.CurrentVersion++
+		secret.Versions[secret.CurrentVersion] = value
+		writeData(w, map[string]interface{}{"version": secret.CurrentVersion})
+	case http.MethodDelete:
+		if secret != nil {
+			secret.Deleted[secret.CurrentVersion] = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// function to serve the KV v2 metadata endpoint
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}) {
+	secret := s.secrets[path]
+	switch r.Method {
+	case http.MethodGet:
+		if secret == nil {
+			writeError(w, http.StatusNotFound, "")
+			return
+		}
+		writeData(w, map[string]interface{}{"current_version": secret.CurrentVersion, "custom_metadata": secret.CustomMetadata})
+	case http.MethodPost, http.MethodPut:
+		if secret == nil {
+			secret = &Secret{Versions: map[int]map[string]interface{}{}, Deleted: map[int]bool{}}
+			s.secrets[path] = secret
+		}
+		secret.CustomMetadata = map[string]string{} // custom metadata is replaced, like in Vault
+		if customMetadata, ok := body["custom_metadata"].(map[string]interface{}); ok {
+			for key, value := range customMetadata {
+				secret.CustomMetadata[key], _ = value.(string)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(s.secrets, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// function to serve the KV v2 destroy endpoint, the data of the versions is removed for good
+func (s *Server) destroy(w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if secret := s.secrets[path]; secret != nil {
+		versions, _ := body["versions"].([]interface{})
+		for _, version := range versions {
+			if v, ok := version.(float64); ok {
+				delete(secret.Versions, int(v))
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	errors := []string{}
+	if message != "" {
+		errors = append(errors, message)
+	}
+	writeJSON(w, status, map[string]interface{}{"errors": errors})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}